@@ -1,49 +1,149 @@
 package bridge
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/confighub/sdk/bridge-worker/api"
 	"github.com/confighub/sdk/bridge-worker/lib"
+	goclientnew "github.com/confighub/sdk/openapi/goclient-new"
 	"github.com/confighub/sdk/workerapi"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
 	"github.com/gosimple/slug"
 
 	"github.com/confighubai/flux-bridge/internal/controller"
+	"github.com/confighubai/flux-bridge/internal/metrics"
+	"github.com/confighubai/flux-bridge/internal/queue"
 )
 
 var _ api.Bridge = &FluxBridge{}
 
+const (
+	// KustomizationProviderType deploys units with a Kustomization.
+	KustomizationProviderType = api.ProviderType("FluxExternalArtifact")
+	// HelmReleaseProviderType deploys units containing a chart package with a HelmRelease.
+	HelmReleaseProviderType = api.ProviderType("FluxHelmRelease")
+
+	// infoTimeout bounds the discovery of the remote clusters when the worker info is
+	// requested.
+	infoTimeout = 10 * time.Second
+)
+
 type FluxBridge struct {
-	fluxCtrl controller.FluxController
-	name     string
+	// ctx is used for the requests made outside of an operation, as Info is called
+	// without a context.
+	ctx          context.Context
+	fluxCtrl     controller.FluxController
+	name         string
+	confighubURL string
+	queue        *queue.Queue
+	metrics      *metrics.Recorder
+	log          logr.Logger
 }
 
 func NewFluxBridge(fluxCtrl controller.FluxController, name string) (*FluxBridge, error) {
 	return &FluxBridge{
+		ctx:      context.Background(),
 		fluxCtrl: fluxCtrl,
 		name:     name,
+		log:      logr.Discard(),
 	}, nil
 }
 
+// WithMetrics returns a copy of the bridge recording metrics of its operations.
+func (b *FluxBridge) WithMetrics(recorder *metrics.Recorder) *FluxBridge {
+	bridge := *b
+	bridge.metrics = recorder
+	return &bridge
+}
+
+// WithConfigHubURL returns a copy of the bridge linking the objects it creates to
+// their units in the ConfigHub at the URL.
+func (b *FluxBridge) WithConfigHubURL(url string) *FluxBridge {
+	bridge := *b
+	bridge.confighubURL = strings.TrimSuffix(url, "/")
+	return &bridge
+}
+
+// WithQueue returns a copy of the bridge running its operations through the queue,
+// which serializes the operations on a unit and limits how many run at once.
+func (b *FluxBridge) WithQueue(q *queue.Queue) *FluxBridge {
+	bridge := *b
+	bridge.queue = q
+	return &bridge
+}
+
+// WithContext returns a copy of the bridge which stops the requests it makes outside of
+// an operation when the context is cancelled.
+func (b *FluxBridge) WithContext(ctx context.Context) *FluxBridge {
+	bridge := *b
+	bridge.ctx = ctx
+	return &bridge
+}
+
+// WithLogger returns a copy of the bridge logging its operations to the logger.
+func (b *FluxBridge) WithLogger(log logr.Logger) *FluxBridge {
+	bridge := *b
+	bridge.log = log
+	return &bridge
+}
+
 func (b *FluxBridge) Info(opts api.InfoOptions) api.BridgeInfo {
+	configTypes := []*api.ConfigType{
+		{
+			ToolchainType:    workerapi.ToolchainKubernetesYAML,
+			ProviderType:     KustomizationProviderType,
+			AvailableTargets: b.targets(b.name),
+		},
+	}
+	if b.fluxCtrl.SupportsHelmRelease() {
+		configTypes = append(configTypes, &api.ConfigType{
+			ToolchainType:    workerapi.ToolchainKubernetesYAML,
+			ProviderType:     HelmReleaseProviderType,
+			AvailableTargets: b.targets(b.name + "-helm"),
+		})
+	}
 	return api.BridgeInfo{
-		SupportedConfigTypes: []*api.ConfigType{
-			{
-				ToolchainType: workerapi.ToolchainKubernetesYAML,
-				ProviderType:  api.ProviderType("FluxExternalArtifact"),
-				AvailableTargets: []api.Target{
-					{
-						Name: slug.Make(b.name),
-					},
-				},
-			},
+		SupportedConfigTypes: configTypes,
+	}
+}
+
+// targets returns the target for the local cluster, followed by a target for each
+// remote cluster with a kubeconfig Secret in the bridge namespace.
+func (b *FluxBridge) targets(name string) []api.Target {
+	targets := []api.Target{
+		{
+			Name: slug.Make(name),
 		},
 	}
+	// Remote clusters are optional, the local cluster is still advertised when listing fails.
+	ctx, cancel := context.WithTimeout(b.ctx, infoTimeout)
+	defer cancel()
+	clusters, err := b.fluxCtrl.Clusters(ctx)
+	if err != nil {
+		return targets
+	}
+	for _, cluster := range clusters {
+		targets = append(targets, api.Target{
+			Name: slug.Make(name + "-" + cluster.Name),
+			Params: map[string]any{
+				"kubeConfigSecret": cluster.SecretName,
+			},
+		})
+	}
+	return targets
 }
 
 func (b *FluxBridge) Apply(wctx api.BridgeContext, payload api.BridgePayload) error {
+	wctx, done := b.observe(wctx, payload, "apply")
+	defer done()
+
 	err := wctx.SendStatus(&api.ActionResult{
 		ActionResultBaseMeta: api.ActionResultMeta{
 			Status:  api.ActionStatusProgressing,
@@ -55,29 +155,105 @@ func (b *FluxBridge) Apply(wctx api.BridgeContext, payload api.BridgePayload) er
 		return err
 	}
 
+	lease, err := b.acquire(wctx, payload, true)
+	// The newer revision is applied instead, so this revision was never applied, but
+	// the operation itself did not fail.
+	var supersededErr *queue.SupersededError
+	if errors.As(err, &supersededErr) {
+		return wctx.SendStatus(&api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusCanceled,
+				Result:  api.ActionResultApplyFailed,
+				Message: fmt.Sprintf("Superseded by revision %d", supersededErr.Revision),
+			},
+		})
+	}
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultApplyFailed,
+				Message: fmt.Sprintf("Operation not run: %s", err.Error()),
+			},
+		}, err)
+	}
+	defer lease.Release()
+
+	opts, err := parseParams(payload, b.dependencyName(wctx.Context()))
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultApplyFailed,
+				Message: fmt.Sprintf("Invalid parameters: %s", err.Error()),
+			},
+		}, err)
+	}
+
+	opts.Progress = sendProgress(wctx)
+	// Other operations can run while the unit waits on its dependencies.
+	opts.Yield = func(wait func() error) error {
+		return lease.Yield(wctx.Context(), queue.ProgressFunc(opts.Progress), wait)
+	}
+
+	opts.Space = payload.SpaceSlug
+	opts.Annotations = b.origin(payload).Annotations()
+	opts.LegacyName = legacyUnitName(payload.SpaceSlug, payload.UnitSlug)
+
+	name := payloadToName(payload)
 	version := fmt.Sprintf("%d", payload.RevisionNum)
-	err = b.fluxCtrl.Apply(wctx.Context(), payloadToName(payload), version, payload.Data)
+	switch payload.ProviderType {
+	case HelmReleaseProviderType:
+		err = b.fluxCtrl.ApplyHelmRelease(wctx.Context(), name, version, payload.Data, opts)
+	default:
+		err = b.fluxCtrl.Apply(wctx.Context(), name, version, payload.Data, opts)
+	}
+	rollbackErr := &controller.RollbackError{}
+	if errors.As(err, &rollbackErr) {
+		// The apply failed but the previous revision is running, so report its live state.
+		liveState, _ := b.liveState(wctx.Context(), name, payload)
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultApplyFailed,
+				Message: truncateMessage(fmt.Sprintf("Flux controller apply error: %s", err.Error())),
+			},
+			LiveState: liveState,
+		}, err)
+	}
 	if err != nil {
 		return lib.SafeSendStatus(wctx, &api.ActionResult{
 			ActionResultBaseMeta: api.ActionResultMeta{
 				Status:  api.ActionStatusFailed,
 				Result:  api.ActionResultApplyFailed,
-				Message: fmt.Sprintf("Flux controller apply error: %s", err.Error()),
+				Message: truncateMessage(fmt.Sprintf("Flux controller apply error: %s", err.Error())),
 			},
+			Outputs: errorOutputs(err),
 		}, err)
 	}
 
+	msg := "Successfully completed apply operation"
+	if opts.Suspend {
+		msg = fmt.Sprintf("Stored revision %s, but the unit is suspended and is not deployed until it is resumed", version)
+	}
+	liveState, err := b.liveState(wctx.Context(), name, payload)
+	if err != nil {
+		msg = fmt.Sprintf("%s, but could not read live state: %s", msg, err.Error())
+	}
 	return wctx.SendStatus(&api.ActionResult{
 		ActionResultBaseMeta: api.ActionResultMeta{
 			Status:  api.ActionStatusCompleted,
 			Result:  api.ActionResultApplyCompleted,
-			Message: "Successfully completed apply operation",
+			Message: msg,
 		},
-		LiveState: payload.Data,
+		LiveState: liveState,
 	})
 }
 
 func (b *FluxBridge) Refresh(wctx api.BridgeContext, payload api.BridgePayload) error {
+	wctx, done := b.observe(wctx, payload, "refresh")
+	defer done()
+
 	err := wctx.SendStatus(&api.ActionResult{
 		ActionResultBaseMeta: api.ActionResultMeta{
 			Status:  api.ActionStatusProgressing,
@@ -89,7 +265,40 @@ func (b *FluxBridge) Refresh(wctx api.BridgeContext, payload api.BridgePayload)
 		return err
 	}
 
-	drift, msg, err := b.fluxCtrl.Diff(wctx.Context(), payloadToName(payload), payload.Data)
+	lease, err := b.acquire(wctx, payload, false)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultRefreshFailed,
+				Message: fmt.Sprintf("Operation not run: %s", err.Error()),
+			},
+		}, err)
+	}
+	defer lease.Release()
+
+	opts, err := parseParams(payload, b.dependencyName(wctx.Context()))
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultRefreshFailed,
+				Message: fmt.Sprintf("Invalid parameters: %s", err.Error()),
+			},
+		}, err)
+	}
+	name, err := b.resolveName(wctx.Context(), payload)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultRefreshFailed,
+				Message: fmt.Sprintf("Flux controller name error: %s", err.Error()),
+			},
+		}, err)
+	}
+
+	drift, msg, err := b.diff(wctx.Context(), name, payload)
 	if err != nil {
 		return lib.SafeSendStatus(wctx, &api.ActionResult{
 			ActionResultBaseMeta: api.ActionResultMeta{
@@ -99,6 +308,50 @@ func (b *FluxBridge) Refresh(wctx api.BridgeContext, payload api.BridgePayload)
 			},
 		}, err)
 	}
+	if drift {
+		b.metrics.RecordDrift(string(payload.ProviderType))
+	}
+	if drift && opts.Reconcile {
+		// Correct the drift now instead of waiting for the next interval.
+		switch payload.ProviderType {
+		case HelmReleaseProviderType:
+			err = b.fluxCtrl.ReconcileHelmRelease(wctx.Context(), name, sendProgress(wctx))
+		default:
+			err = b.fluxCtrl.Reconcile(wctx.Context(), name, sendProgress(wctx))
+		}
+		if err != nil {
+			return lib.SafeSendStatus(wctx, &api.ActionResult{
+				ActionResultBaseMeta: api.ActionResultMeta{
+					Status:  api.ActionStatusFailed,
+					Result:  api.ActionResultRefreshFailed,
+					Message: truncateMessage(fmt.Sprintf("Flux controller reconcile error: %s", err.Error())),
+				},
+			}, err)
+		}
+		driftMsg := msg
+		drift, msg, err = b.diff(wctx.Context(), name, payload)
+		if err != nil {
+			return lib.SafeSendStatus(wctx, &api.ActionResult{
+				ActionResultBaseMeta: api.ActionResultMeta{
+					Status:  api.ActionStatusFailed,
+					Result:  api.ActionResultRefreshFailed,
+					Message: fmt.Sprintf("Flux controller diff error: %s", err.Error()),
+				},
+			}, err)
+		}
+		msg = fmt.Sprintf("%s; reconciled to correct it: %s", driftMsg, msg)
+	}
+
+	liveState, err := b.liveState(wctx.Context(), name, payload)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultRefreshFailed,
+				Message: fmt.Sprintf("Flux controller live state error: %s", err.Error()),
+			},
+		}, err)
+	}
 
 	result := api.ActionResultRefreshAndNoDrift
 	if drift {
@@ -108,19 +361,97 @@ func (b *FluxBridge) Refresh(wctx api.BridgeContext, payload api.BridgePayload)
 		ActionResultBaseMeta: api.ActionResultMeta{
 			Status:  api.ActionStatusCompleted,
 			Result:  result,
-			Message: msg,
+			Message: truncateMessage(msg),
 		},
 		Data:      payload.Data,
-		LiveState: payload.Data,
+		LiveState: liveState,
 	})
 }
 
-func (b *FluxBridge) Import(ctx api.BridgeContext, payload api.BridgePayload) error {
-	// TODO(phillebaba): Figure out in what scenario we would support imports.
-	return errors.New("import not supported")
+// diff compares the unit data with the objects in the cluster deployed by the Flux
+// object with the name.
+func (b *FluxBridge) diff(ctx context.Context, name string, payload api.BridgePayload) (bool, string, error) {
+	switch payload.ProviderType {
+	case HelmReleaseProviderType:
+		return b.fluxCtrl.DiffHelmRelease(ctx, name, payload.Data)
+	default:
+		return b.fluxCtrl.Diff(ctx, name, payload.Data)
+	}
+}
+
+func (b *FluxBridge) Import(wctx api.BridgeContext, payload api.BridgePayload) error {
+	wctx, done := b.observe(wctx, payload, "import")
+	defer done()
+
+	err := wctx.SendStatus(&api.ActionResult{
+		ActionResultBaseMeta: api.ActionResultMeta{
+			Status:  api.ActionStatusProgressing,
+			Result:  api.ActionResultNone,
+			Message: "Starting import operation",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	lease, err := b.acquire(wctx, payload, false)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultImportFailed,
+				Message: fmt.Sprintf("Operation not run: %s", err.Error()),
+			},
+		}, err)
+	}
+	defer lease.Release()
+
+	if payload.ProviderType == HelmReleaseProviderType {
+		err := errors.New("import is only supported for Kustomizations")
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultImportFailed,
+				Message: fmt.Sprintf("Invalid import request: %s", err.Error()),
+			},
+		}, err)
+	}
+	name, importOpts, err := importRequest(payload)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultImportFailed,
+				Message: fmt.Sprintf("Invalid import request: %s", err.Error()),
+			},
+		}, err)
+	}
+	data, liveState, err := b.fluxCtrl.Import(wctx.Context(), name, importOpts)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultImportFailed,
+				Message: fmt.Sprintf("Flux controller import error: %s", err.Error()),
+			},
+		}, err)
+	}
+
+	return wctx.SendStatus(&api.ActionResult{
+		ActionResultBaseMeta: api.ActionResultMeta{
+			Status:  api.ActionStatusCompleted,
+			Result:  api.ActionResultImportCompleted,
+			Message: fmt.Sprintf("Imported Kustomization %s", name),
+		},
+		Data:      data,
+		LiveState: liveState,
+	})
 }
 
 func (b *FluxBridge) Destroy(wctx api.BridgeContext, payload api.BridgePayload) error {
+	wctx, done := b.observe(wctx, payload, "destroy")
+	defer done()
+
 	err := wctx.SendStatus(&api.ActionResult{
 		ActionResultBaseMeta: api.ActionResultMeta{
 			Status:  api.ActionStatusProgressing,
@@ -132,7 +463,22 @@ func (b *FluxBridge) Destroy(wctx api.BridgeContext, payload api.BridgePayload)
 		return err
 	}
 
-	err = b.fluxCtrl.Delete(wctx.Context(), payloadToName(payload))
+	lease, err := b.acquire(wctx, payload, false)
+	if err != nil {
+		return lib.SafeSendStatus(wctx, &api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusFailed,
+				Result:  api.ActionResultDestroyFailed,
+				Message: fmt.Sprintf("Operation not run: %s", err.Error()),
+			},
+		}, err)
+	}
+	defer lease.Release()
+
+	name, err := b.resolveName(wctx.Context(), payload)
+	if err == nil {
+		err = b.fluxCtrl.Delete(wctx.Context(), name)
+	}
 	if err != nil {
 		return lib.SafeSendStatus(wctx, &api.ActionResult{
 			ActionResultBaseMeta: api.ActionResultMeta{
@@ -159,6 +505,160 @@ func (b *FluxBridge) Finalize(ctx api.BridgeContext, payload api.BridgePayload)
 	return nil
 }
 
-func payloadToName(payload api.BridgeWorkerPayload) string {
-	return strings.Join([]string{payload.SpaceSlug, payload.UnitSlug}, "-")
+// liveState returns the live state of the Flux object with the name for the provider
+// type of the payload.
+func (b *FluxBridge) liveState(ctx context.Context, name string, payload api.BridgePayload) ([]byte, error) {
+	switch payload.ProviderType {
+	case HelmReleaseProviderType:
+		return b.fluxCtrl.HelmReleaseLiveState(ctx, name)
+	default:
+		return b.fluxCtrl.LiveState(ctx, name)
+	}
+}
+
+// importRequest returns the name of the Kustomization to import and the import options.
+// The name is read from a Kustomization filter in the import request, and defaults to the
+// unit slug, as an existing Kustomization is usually imported into a unit named after it.
+// The Kustomization is suspended when the suspend option of the request is true.
+func importRequest(payload api.BridgePayload) (string, controller.ImportOptions, error) {
+	name := payload.UnitSlug
+	opts := controller.ImportOptions{}
+	if len(payload.ExtraParams) == 0 {
+		return name, opts, nil
+	}
+	req := goclientnew.ImportRequest{}
+	err := json.Unmarshal(payload.ExtraParams, &req)
+	if err != nil {
+		return "", controller.ImportOptions{}, err
+	}
+	for _, filter := range req.Filters {
+		if filter.Type != kcv1.KustomizationKind {
+			continue
+		}
+		if len(filter.Values) != 1 {
+			return "", controller.ImportOptions{}, fmt.Errorf("expected a single Kustomization name but got %d", len(filter.Values))
+		}
+		name = filter.Values[0]
+		break
+	}
+	if req.Options != nil {
+		if v, ok := (*req.Options)["suspend"]; ok {
+			suspend, ok := v.(bool)
+			if !ok {
+				return "", controller.ImportOptions{}, fmt.Errorf("invalid suspend option %v, expected a bool", v)
+			}
+			opts.Suspend = suspend
+		}
+	}
+	return name, opts, nil
+}
+
+// errorOutputs returns the per object errors of a rejected unit as JSON outputs, as
+// the message may be truncated.
+func errorOutputs(err error) []byte {
+	validationErr := &controller.ValidationError{}
+	if errors.As(err, &validationErr) {
+		b, _ := json.Marshal(validationErr)
+		return b
+	}
+	policyErr := &controller.PolicyError{}
+	if errors.As(err, &policyErr) {
+		b, _ := json.Marshal(policyErr)
+		return b
+	}
+	return nil
+}
+
+// truncateMessage shortens the message to the maximum length accepted by ConfigHub.
+func truncateMessage(msg string) string {
+	if len(msg) <= api.MaxActionResultMessageLength {
+		return msg
+	}
+	return msg[:api.MaxActionResultMessageLength-3] + "..."
+}
+
+// sendProgress returns a progress function reporting the messages as progressing status.
+func sendProgress(wctx api.BridgeContext) controller.ProgressFunc {
+	return func(msg string) {
+		// Progress is informational, failing to send it should not fail the operation.
+		_ = wctx.SendStatus(&api.ActionResult{
+			ActionResultBaseMeta: api.ActionResultMeta{
+				Status:  api.ActionStatusProgressing,
+				Result:  api.ActionResultNone,
+				Message: truncateMessage(msg),
+			},
+		})
+	}
+}
+
+// acquire waits until an operation can run on the unit in the payload, reporting
+// the wait as progress, and returns a lease which must be released when it completed.
+// Supersedable operations are dropped when a newer revision is queued behind them.
+func (b *FluxBridge) acquire(wctx api.BridgeContext, payload api.BridgePayload, supersedable bool) (*queue.Lease, error) {
+	op := queue.Operation{Revision: payload.RevisionNum, Supersedable: supersedable}
+	return b.queue.Acquire(wctx.Context(), payloadToName(payload), op, queue.ProgressFunc(sendProgress(wctx)))
+}
+
+// origin returns the ConfigHub unit revision the payload was sent for.
+func (b *FluxBridge) origin(payload api.BridgePayload) controller.Origin {
+	origin := controller.Origin{
+		Space:    payload.SpaceSlug,
+		Unit:     payload.UnitSlug,
+		Revision: payload.RevisionNum,
+		Worker:   b.name,
+	}
+	if payload.UnitID != uuid.Nil {
+		origin.UnitID = payload.UnitID.String()
+		if b.confighubURL != "" {
+			origin.URL = fmt.Sprintf("%s/space/%s/unit/%s", b.confighubURL, payload.SpaceID, payload.UnitID)
+		}
+	}
+	return origin
+}
+
+// operationContext is the context of a single operation. It carries a logger tagged
+// with the unit and operation, the origin added to the events recorded for it, and
+// remembers the last status sent to ConfigHub.
+type operationContext struct {
+	api.BridgeContext
+	ctx    context.Context
+	status api.ActionResultMeta
+}
+
+func (o *operationContext) Context() context.Context {
+	return o.ctx
+}
+
+func (o *operationContext) SendStatus(result *api.ActionResult) error {
+	o.status = result.ActionResultBaseMeta
+	log := logr.FromContextOrDiscard(o.ctx)
+	if result.Status == api.ActionStatusProgressing {
+		log.V(1).Info(result.Message, "result", result.Result)
+	}
+	return o.BridgeContext.SendStatus(result)
+}
+
+// observe wraps the context of an operation, and returns a function logging the
+// result of its last status and recording it with the duration in the metrics.
+func (b *FluxBridge) observe(wctx api.BridgeContext, payload api.BridgePayload, operation string) (api.BridgeContext, func()) {
+	start := time.Now()
+	log := b.log.WithValues(
+		"space", payload.SpaceSlug,
+		"unit", payload.UnitSlug,
+		"revision", payload.RevisionNum,
+		"operation", operation,
+	)
+	opCtx := &operationContext{
+		BridgeContext: wctx,
+		ctx:           controller.ContextWithOrigin(logr.NewContext(wctx.Context(), log), b.origin(payload)),
+	}
+	return opCtx, func() {
+		duration := time.Since(start)
+		b.metrics.RecordOperation(operation, string(opCtx.status.Result), duration)
+		if opCtx.status.Status == api.ActionStatusFailed {
+			log.Error(errors.New(opCtx.status.Message), "Operation failed", "result", opCtx.status.Result, "duration", duration)
+			return
+		}
+		log.Info(opCtx.status.Message, "result", opCtx.status.Result, "duration", duration)
+	}
 }
@@ -1,18 +1,311 @@
 package bridge
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
 	"testing"
 
+	"github.com/confighub/sdk/bridge-worker/api"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"github.com/confighubai/flux-bridge/internal/controller"
+	"github.com/confighubai/flux-bridge/internal/queue"
 )
 
 func TestFluxBridge(t *testing.T) {
 	t.Parallel()
 
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "prod-kubeconfig",
+			Namespace: "confighub",
+			Labels: map[string]string{
+				controller.ClusterLabelKey: "prod",
+			},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(secret).
+		Build()
+	fluxCtrl, err := controller.NewFluxController(t.Context(), nil, kubeClient, "confighub")
+	require.NoError(t, err)
+
 	name := "test"
-	bridge, err := NewFluxBridge(controller.FluxController{}, name)
+	bridge, err := NewFluxBridge(fluxCtrl, name)
 	require.NoError(t, err)
 	require.Equal(t, name, bridge.name)
+	info := bridge.Info(api.InfoOptions{})
+	require.Len(t, info.SupportedConfigTypes, 1)
+	require.Equal(t, KustomizationProviderType, info.SupportedConfigTypes[0].ProviderType)
+	expectedTargets := []api.Target{
+		{
+			Name: "test",
+		},
+		{
+			Name: "test-prod",
+			Params: map[string]any{
+				"kubeConfigSecret": "prod-kubeconfig",
+			},
+		},
+	}
+	require.Equal(t, expectedTargets, info.SupportedConfigTypes[0].AvailableTargets)
+}
+
+func TestUnitName(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "space-unit-eba1a25e", unitName("space", "unit"))
+	require.Equal(t, "space-unit", legacyUnitName("space", "unit"))
+
+	// Slugs which joined to the same legacy name get different names.
+	require.Equal(t, legacyUnitName("a-b", "c"), legacyUnitName("a", "b-c"))
+	require.NotEqual(t, unitName("a-b", "c"), unitName("a", "b-c"))
+
+	// Long slugs are truncated to fit the name length, keeping the hash.
+	long := unitName(strings.Repeat("s", 40), strings.Repeat("u", 40))
+	require.Len(t, long, maxNameLength)
+	require.True(t, strings.HasPrefix(long, strings.Repeat("s", 40)+"-"))
+	require.NotEqual(t, long, unitName(strings.Repeat("s", 40), strings.Repeat("u", 41)))
+
+	// The prefix does not end with a dash when truncated at one.
+	name := unitName(strings.Repeat("s", 53), "unit")
+	require.Equal(t, strings.Repeat("s", 53)+"-"+name[len(name)-nameHashLength:], name)
+}
+
+func TestUnitLocker(t *testing.T) {
+	t.Parallel()
+
+	q, err := queue.New(1)
+	require.NoError(t, err)
+	locker := NewUnitLocker(q)
+
+	// Objects are locked with the key of the operations on their unit.
+	annotations := controller.Origin{Space: "a", Unit: "b-c"}.Annotations()
+	unlock, ok := q.TryLock(unitName("a", "b-c"))
+	require.True(t, ok)
+	_, ok = locker.TryLock(unitName("a", "b-c"), annotations)
+	require.False(t, ok)
+	// Objects with a legacy name may belong to any unit the name can be split into.
+	_, ok = locker.TryLock(legacyUnitName("a", "b-c"), nil)
+	require.False(t, ok)
+	unlock()
+
+	unlock, ok = locker.TryLock(legacyUnitName("a", "b-c"), nil)
+	require.True(t, ok)
+	_, ok = q.TryLock(unitName("a-b", "c"))
+	require.False(t, ok)
+	unlock()
+	require.Equal(t, []string{unitName("a", "b-c"), unitName("a-b", "c")}, lockKeys("a-b-c", nil))
+	require.Equal(t, []string{unitName("a", "b-c")}, lockKeys("a-b-c-1234abcd", annotations))
+}
+
+func TestImportRequest(t *testing.T) {
+	t.Parallel()
+
+	payload := api.BridgePayload{
+		SpaceSlug: "space",
+		UnitSlug:  "unit",
+	}
+	name, opts, err := importRequest(payload)
+	require.NoError(t, err)
+	require.Equal(t, "unit", name)
+	require.False(t, opts.Suspend)
+
+	payload.ExtraParams = []byte(`{"Filters":[{"Type":"Kustomization","Operator":"=","Values":["apps"]}],"Options":{"suspend":true}}`)
+	name, opts, err = importRequest(payload)
+	require.NoError(t, err)
+	require.Equal(t, "apps", name)
+	require.True(t, opts.Suspend)
+
+	payload.ExtraParams = []byte(`{"Options":{"suspend":"yes"}}`)
+	_, _, err = importRequest(payload)
+	require.EqualError(t, err, "invalid suspend option yes, expected a bool")
+
+	payload.ExtraParams = []byte(`{"Filters":[{"Type":"Kustomization","Values":["apps","infra"]}]}`)
+	_, _, err = importRequest(payload)
+	require.EqualError(t, err, "expected a single Kustomization name but got 2")
+}
+
+func TestRefreshExtraParams(t *testing.T) {
+	t.Parallel()
+
+	sc := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(sc))
+	require.NoError(t, kcv1.AddToScheme(sc))
+	require.NoError(t, scv1.AddToScheme(sc))
+	kubeClient := fake.NewClientBuilder().WithScheme(sc).Build()
+	fluxCtrl, err := controller.NewFluxController(t.Context(), nil, kubeClient, "confighub")
+	require.NoError(t, err)
+	bridge, err := NewFluxBridge(fluxCtrl, "test")
+	require.NoError(t, err)
+
+	// ConfigHub sends the base revision of a refresh in the extra parameters.
+	extraParams, err := json.Marshal(api.RefreshParams{BaseRevisionData: []byte("apiVersion: v1")})
+	require.NoError(t, err)
+	payload := api.BridgePayload{
+		SpaceSlug:   "apps",
+		UnitSlug:    "podinfo",
+		ExtraParams: extraParams,
+	}
+	wctx := &fakeBridgeContext{ctx: t.Context()}
+	err = bridge.Refresh(wctx, payload)
+	require.NoError(t, err)
+	result := wctx.statuses[len(wctx.statuses)-1]
+	require.Equal(t, api.ActionStatusCompleted, result.Status)
+	require.Equal(t, api.ActionResultRefreshAndDrifted, result.Result)
+	require.Equal(t, "Kustomization "+unitName("apps", "podinfo")+" could not be found", result.Message)
+}
+
+type fakeBridgeContext struct {
+	ctx      context.Context
+	statuses []*api.ActionResult
+}
+
+func (f *fakeBridgeContext) Context() context.Context { return f.ctx }
+func (f *fakeBridgeContext) GetServerURL() string     { return "" }
+func (f *fakeBridgeContext) GetWorkerID() string      { return "" }
+func (f *fakeBridgeContext) SendStatus(result *api.ActionResult) error {
+	f.statuses = append(f.statuses, result)
+	return nil
+}
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{}
+	log := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: 1})
+	bridge := (&FluxBridge{}).WithLogger(log)
+	fake := &fakeBridgeContext{ctx: t.Context()}
+	payload := api.BridgePayload{
+		SpaceSlug:   "apps",
+		UnitSlug:    "podinfo",
+		RevisionNum: 3,
+	}
+
+	wctx, done := bridge.observe(fake, payload, "apply")
+	_, err := logr.FromContext(wctx.Context())
+	require.NoError(t, err)
+	origin, ok := controller.OriginFromContext(wctx.Context())
+	require.True(t, ok)
+	require.Equal(t, controller.Origin{Space: "apps", Unit: "podinfo", Revision: 3}, origin)
+	sendProgress(wctx)("Waiting for Kustomization")
+	err = wctx.SendStatus(&api.ActionResult{
+		ActionResultBaseMeta: api.ActionResultMeta{
+			Status:  api.ActionStatusFailed,
+			Result:  api.ActionResultApplyFailed,
+			Message: "Flux controller apply error: timeout",
+		},
+	})
+	require.NoError(t, err)
+	done()
+
+	require.Len(t, fake.statuses, 2)
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		require.Contains(t, line, `"space"="apps" "unit"="podinfo" "revision"=3 "operation"="apply"`)
+	}
+	require.True(t, strings.HasPrefix(lines[0], `"level"=1 "msg"="Waiting for Kustomization"`))
+	require.Contains(t, lines[1], `"msg"="Operation failed"`)
+	require.Contains(t, lines[1], `"error"="Flux controller apply error: timeout"`)
+	require.Contains(t, lines[1], `"result"="ApplyFailed"`)
+}
+
+func TestOrigin(t *testing.T) {
+	t.Parallel()
+
+	spaceID := uuid.MustParse("6f1c0d52-7c2e-4a8e-b1a4-3f6d2c9e8b01")
+	unitID := uuid.MustParse("0d7b5a3e-4a3b-4b8e-9f3a-5c2d1e6f7a8b")
+	payload := api.BridgePayload{
+		SpaceSlug:   "apps",
+		SpaceID:     spaceID,
+		UnitSlug:    "podinfo",
+		UnitID:      unitID,
+		RevisionNum: 3,
+	}
+	bridge := (&FluxBridge{name: "flux-bridge"}).WithConfigHubURL("https://hub.confighub.com/")
+	require.Equal(t, controller.Origin{
+		Space:    "apps",
+		Unit:     "podinfo",
+		UnitID:   unitID.String(),
+		Revision: 3,
+		URL:      "https://hub.confighub.com/space/6f1c0d52-7c2e-4a8e-b1a4-3f6d2c9e8b01/unit/0d7b5a3e-4a3b-4b8e-9f3a-5c2d1e6f7a8b",
+		Worker:   "flux-bridge",
+	}, bridge.origin(payload))
+
+	// Without a unit ID there is nothing to link to.
+	payload.UnitID = uuid.Nil
+	origin := bridge.origin(payload)
+	require.Empty(t, origin.UnitID)
+	require.Empty(t, origin.URL)
+}
+
+func TestApplySuperseded(t *testing.T) {
+	t.Parallel()
+
+	q, err := queue.New(1)
+	require.NoError(t, err)
+	bridge := (&FluxBridge{}).WithQueue(q)
+	payload := api.BridgePayload{
+		SpaceSlug:   "apps",
+		UnitSlug:    "podinfo",
+		RevisionNum: 1,
+	}
+	// Hold the unit so the applies are queued.
+	unlock, ok := q.TryLock(payloadToName(payload))
+	require.True(t, ok)
+	defer unlock()
+
+	queued := make(chan struct{}, 1)
+	first := &queuedBridgeContext{fakeBridgeContext: fakeBridgeContext{ctx: t.Context()}, queued: queued}
+	done := make(chan error)
+	go func() {
+		done <- bridge.Apply(first, payload)
+	}()
+	<-queued
+
+	ctx, cancel := context.WithCancel(t.Context())
+	second := &fakeBridgeContext{ctx: ctx}
+	payload.RevisionNum = 2
+	go func() {
+		bridge.Apply(second, payload)
+		close(done)
+	}()
+	err = <-done
+	require.NoError(t, err)
+	require.Len(t, first.statuses, 3)
+	require.Equal(t, "Queued behind 1 operation(s) on the unit", first.statuses[1].Message)
+	require.Equal(t, api.ActionStatusCanceled, first.statuses[2].Status)
+	require.Equal(t, api.ActionResultApplyFailed, first.statuses[2].Result)
+	require.Equal(t, "Superseded by revision 2", first.statuses[2].Message)
+
+	cancel()
+	<-done
+}
+
+// queuedBridgeContext signals when an operation reports that it is queued.
+type queuedBridgeContext struct {
+	fakeBridgeContext
+	queued chan struct{}
+}
+
+func (q *queuedBridgeContext) SendStatus(result *api.ActionResult) error {
+	if strings.HasPrefix(result.Message, "Queued behind") {
+		q.queued <- struct{}{}
+	}
+	return q.fakeBridgeContext.SendStatus(result)
 }
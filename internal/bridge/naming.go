@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/confighub/sdk/bridge-worker/api"
+
+	"github.com/confighubai/flux-bridge/internal/controller"
+	"github.com/confighubai/flux-bridge/internal/queue"
+)
+
+const (
+	// maxNameLength is the maximum length of the names of the Flux objects, which
+	// kustomize-controller and helm-controller use as label values.
+	maxNameLength = 63
+	// nameHashLength is the length of the hash suffix of the names.
+	nameHashLength = 8
+)
+
+// nameFunc returns the name of the Flux objects of the unit in the space.
+type nameFunc func(spaceSlug, unitSlug string) (string, error)
+
+func payloadToName(payload api.BridgeWorkerPayload) string {
+	return unitName(payload.SpaceSlug, payload.UnitSlug)
+}
+
+// unitName returns the name of the Flux objects created for the unit in the space. It
+// is the space and unit slugs joined by a dash, truncated to fit the name length, with
+// a hash of both slugs as suffix so the names of different units never collide.
+func unitName(spaceSlug, unitSlug string) string {
+	sum := sha256.Sum256([]byte(spaceSlug + "/" + unitSlug))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+	prefix := legacyUnitName(spaceSlug, unitSlug)
+	if maxPrefix := maxNameLength - nameHashLength - 1; len(prefix) > maxPrefix {
+		prefix = strings.TrimRight(prefix[:maxPrefix], "-")
+	}
+	return prefix + "-" + hash
+}
+
+// legacyUnitName returns the name the Flux objects of the unit in the space were
+// created with before the names got a hash suffix.
+func legacyUnitName(spaceSlug, unitSlug string) string {
+	return strings.Join([]string{spaceSlug, unitSlug}, "-")
+}
+
+// resolveName returns the name of the Flux objects of the unit in the payload, which
+// is its legacy name until the unit is applied and migrated to the current name.
+func (b *FluxBridge) resolveName(ctx context.Context, payload api.BridgePayload) (string, error) {
+	return b.fluxCtrl.ResolveName(ctx, payloadToName(payload), legacyUnitName(payload.SpaceSlug, payload.UnitSlug), b.origin(payload).Annotations())
+}
+
+// dependencyName returns a function resolving the name of the Flux objects of the
+// units the payload depends on, which may not have been migrated yet.
+func (b *FluxBridge) dependencyName(ctx context.Context) nameFunc {
+	return func(spaceSlug, unitSlug string) (string, error) {
+		origin := controller.Origin{Space: spaceSlug, Unit: unitSlug}
+		return b.fluxCtrl.ResolveName(ctx, unitName(spaceSlug, unitSlug), legacyUnitName(spaceSlug, unitSlug), origin.Annotations())
+	}
+}
+
+// unitLocker locks units in the operation queue with the key the operations on the unit
+// are queued under, which is the name of the unit and not the name of its objects while
+// they still have the legacy name.
+type unitLocker struct {
+	queue *queue.Queue
+}
+
+// NewUnitLocker returns a locker locking the units of Flux objects in the queue of the
+// bridge operations, so the controller does not change a unit during an operation.
+func NewUnitLocker(q *queue.Queue) controller.Locker {
+	return unitLocker{queue: q}
+}
+
+// TryLock locks the unit owning the objects, or every unit which may own them when the
+// objects were created before they were annotated with their unit.
+func (l unitLocker) TryLock(name string, annotations map[string]string) (func(), bool) {
+	unlocks := []func(){}
+	unlock := func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+	for _, key := range lockKeys(name, annotations) {
+		unlockKey, ok := l.queue.TryLock(key)
+		if !ok {
+			unlock()
+			return nil, false
+		}
+		unlocks = append(unlocks, unlockKey)
+	}
+	return unlock, true
+}
+
+// lockKeys returns the queue keys of the units which may own the objects with the name.
+// A legacy name is the space and unit slugs joined by a dash, which can be split at any
+// of its dashes as slugs may contain dashes too.
+func lockKeys(name string, annotations map[string]string) []string {
+	space, unit := annotations[controller.SpaceAnnotationKey], annotations[controller.UnitAnnotationKey]
+	if space != "" && unit != "" {
+		return []string{unitName(space, unit)}
+	}
+	keys := []string{}
+	for i, r := range name {
+		if r == '-' {
+			keys = append(keys, unitName(name[:i], name[i+1:]))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/confighub/sdk/bridge-worker/api"
+
+	"github.com/confighubai/flux-bridge/internal/controller"
+)
+
+// Params are the per unit parameters that can be set through the target
+// parameters and overridden through the extra parameters of a payload.
+type Params struct {
+	Interval           string `json:"interval,omitempty"`
+	RetryInterval      string `json:"retryInterval,omitempty"`
+	Timeout            string `json:"timeout,omitempty"`
+	Wait               *bool  `json:"wait,omitempty"`
+	Prune              *bool  `json:"prune,omitempty"`
+	Force              *bool  `json:"force,omitempty"`
+	Suspend            *bool  `json:"suspend,omitempty"`
+	TargetNamespace    string `json:"targetNamespace,omitempty"`
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	KubeConfigSecret   string `json:"kubeConfigSecret,omitempty"`
+	Rollback           *bool  `json:"rollback,omitempty"`
+	Reconcile          *bool  `json:"reconcile,omitempty"`
+	// DependsOn lists the units which must be ready before this unit is applied,
+	// as unit slugs in the same space or space/unit slugs in another space.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Layout    string   `json:"layout,omitempty"`
+}
+
+// parseParams reads the target and extra parameters of the payload and
+// returns the resulting apply options, falling back to the defaults. The names
+// of the units it depends on are resolved with the name function.
+func parseParams(payload api.BridgePayload, names nameFunc) (controller.ApplyOptions, error) {
+	opts := controller.DefaultApplyOptions()
+	for _, p := range []struct {
+		raw    []byte
+		strict bool
+	}{
+		// Reject unknown keys so a misspelled target parameter is not silently ignored.
+		{payload.TargetParams, true},
+		// ConfigHub sends its own data in the extra parameters, like the base revision
+		// of a refresh, so only the keys of the parameters are read from them.
+		{payload.ExtraParams, false},
+	} {
+		if len(p.raw) == 0 {
+			continue
+		}
+		params := Params{}
+		dec := json.NewDecoder(bytes.NewReader(p.raw))
+		if p.strict {
+			dec.DisallowUnknownFields()
+		}
+		err := dec.Decode(&params)
+		if err != nil {
+			return controller.ApplyOptions{}, fmt.Errorf("could not parse parameters: %w", err)
+		}
+		err = params.merge(&opts, payload.SpaceSlug, names)
+		if err != nil {
+			return controller.ApplyOptions{}, err
+		}
+	}
+	err := opts.Validate()
+	if err != nil {
+		return controller.ApplyOptions{}, err
+	}
+	return opts, nil
+}
+
+func (p Params) merge(opts *controller.ApplyOptions, spaceSlug string, names nameFunc) error {
+	for _, d := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"interval", p.Interval, &opts.Interval},
+		{"retryInterval", p.RetryInterval, &opts.RetryInterval},
+		{"timeout", p.Timeout, &opts.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		*d.dest = v
+	}
+	for _, b := range []struct {
+		value *bool
+		dest  *bool
+	}{
+		{p.Wait, &opts.Wait},
+		{p.Prune, &opts.Prune},
+		{p.Force, &opts.Force},
+		{p.Suspend, &opts.Suspend},
+		{p.Rollback, &opts.Rollback},
+		{p.Reconcile, &opts.Reconcile},
+	} {
+		if b.value != nil {
+			*b.dest = *b.value
+		}
+	}
+	if p.TargetNamespace != "" {
+		opts.TargetNamespace = p.TargetNamespace
+	}
+	if p.ServiceAccountName != "" {
+		opts.ServiceAccountName = p.ServiceAccountName
+	}
+	if p.KubeConfigSecret != "" {
+		opts.KubeConfigSecret = p.KubeConfigSecret
+	}
+	if p.Layout != "" {
+		opts.Layout = p.Layout
+	}
+	if p.DependsOn != nil {
+		deps := []string{}
+		for _, dep := range p.DependsOn {
+			space, unit, ok := strings.Cut(dep, "/")
+			if !ok {
+				space, unit = spaceSlug, dep
+			}
+			if space == "" || unit == "" {
+				return fmt.Errorf("invalid dependency %q, expected unit or space/unit", dep)
+			}
+			name, err := names(space, unit)
+			if err != nil {
+				return fmt.Errorf("could not resolve dependency %q: %w", dep, err)
+			}
+			deps = append(deps, name)
+		}
+		opts.DependsOn = deps
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/confighub/sdk/bridge-worker/api"
+	"github.com/stretchr/testify/require"
+
+	"github.com/confighubai/flux-bridge/internal/controller"
+)
+
+func TestParseParams(t *testing.T) {
+	t.Parallel()
+
+	// ConfigHub sends the base revision of a refresh in the extra parameters.
+	refreshParams, err := json.Marshal(api.RefreshParams{BaseRevisionData: []byte("apiVersion: v1")})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		targetParams string
+		extraParams  string
+		expected     controller.ApplyOptions
+		expectedErr  string
+	}{
+		{
+			name:     "defaults",
+			expected: controller.DefaultApplyOptions(),
+		},
+		{
+			name:         "target params",
+			targetParams: `{"timeout":"20m","retryInterval":"30s","prune":false,"targetNamespace":"database","kubeConfigSecret":"prod-kubeconfig"}`,
+			expected: controller.ApplyOptions{
+				Interval:         controller.DefaultInterval,
+				RetryInterval:    30 * time.Second,
+				Timeout:          20 * time.Minute,
+				Wait:             true,
+				Prune:            false,
+				TargetNamespace:  "database",
+				KubeConfigSecret: "prod-kubeconfig",
+			},
+		},
+		{
+			name:         "extra params override target params",
+			targetParams: `{"timeout":"20m","serviceAccountName":"deployer","rollback":true,"reconcile":true}`,
+			extraParams:  `{"timeout":"10m","force":true,"suspend":true}`,
+			expected: controller.ApplyOptions{
+				Interval:           controller.DefaultInterval,
+				Timeout:            10 * time.Minute,
+				Wait:               true,
+				Prune:              true,
+				Force:              true,
+				Suspend:            true,
+				ServiceAccountName: "deployer",
+				Rollback:           true,
+				Reconcile:          true,
+			},
+		},
+		{
+			name:        "dependencies",
+			extraParams: `{"dependsOn":["crds","platform/cert-manager"],"layout":"split"}`,
+			expected: controller.ApplyOptions{
+				Interval:  controller.DefaultInterval,
+				Timeout:   controller.DefaultTimeout,
+				Wait:      true,
+				Prune:     true,
+				DependsOn: []string{unitName("apps", "crds"), unitName("platform", "cert-manager")},
+				Layout:    controller.LayoutSplit,
+			},
+		},
+		{
+			name:        "invalid dependency",
+			extraParams: `{"dependsOn":["platform/"]}`,
+			expectedErr: `invalid dependency "platform/", expected unit or space/unit`,
+		},
+		{
+			name:         "invalid layout",
+			targetParams: `{"layout":"tree"}`,
+			expectedErr:  `invalid layout "tree", expected single or split`,
+		},
+		{
+			name:         "invalid duration",
+			targetParams: `{"interval":"soon"}`,
+			expectedErr:  `invalid interval: time: invalid duration "soon"`,
+		},
+		{
+			name:         "negative timeout",
+			targetParams: `{"timeout":"-1m"}`,
+			expectedErr:  "timeout must be greater than zero, got -1m0s",
+		},
+		{
+			name:         "invalid target namespace",
+			targetParams: `{"targetNamespace":"Not_Valid"}`,
+			expectedErr:  `invalid target namespace "Not_Valid"`,
+		},
+		{
+			name:         "unknown parameter",
+			targetParams: `{"intreval":"1m"}`,
+			expectedErr:  `unknown field "intreval"`,
+		},
+		{
+			name:        "refresh params",
+			extraParams: string(refreshParams),
+			expected:    controller.DefaultApplyOptions(),
+		},
+		{
+			name:         "invalid json",
+			targetParams: `{"timeout":`,
+			expectedErr:  "could not parse parameters",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			payload := api.BridgePayload{
+				SpaceSlug:    "apps",
+				TargetParams: []byte(tt.targetParams),
+				ExtraParams:  []byte(tt.extraParams),
+			}
+			opts, err := parseParams(payload, func(spaceSlug, unitSlug string) (string, error) {
+				return unitName(spaceSlug, unitSlug), nil
+			})
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, opts)
+		})
+	}
+}
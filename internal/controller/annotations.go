@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"maps"
+	"strconv"
+)
+
+// Annotations linking the External Artifacts, Flux objects, the objects they deploy
+// and events back to the ConfigHub unit they were created for.
+const (
+	SpaceAnnotationKey    = "flux-bridge.confighub.com/space"
+	UnitAnnotationKey     = "flux-bridge.confighub.com/unit"
+	UnitIDAnnotationKey   = "flux-bridge.confighub.com/unit-id"
+	RevisionAnnotationKey = "flux-bridge.confighub.com/revision"
+	URLAnnotationKey      = "flux-bridge.confighub.com/url"
+	WorkerAnnotationKey   = "flux-bridge.confighub.com/worker"
+)
+
+// Annotations returns the annotations identifying the origin, leaving out the
+// fields which are not set.
+func (o Origin) Annotations() map[string]string {
+	annotations := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	set(SpaceAnnotationKey, o.Space)
+	set(UnitAnnotationKey, o.Unit)
+	set(UnitIDAnnotationKey, o.UnitID)
+	if o.Revision > 0 {
+		set(RevisionAnnotationKey, strconv.FormatInt(o.Revision, 10))
+	}
+	set(URLAnnotationKey, o.URL)
+	set(WorkerAnnotationKey, o.Worker)
+	return annotations
+}
+
+// originAnnotations returns the annotations identifying the origin out of the annotations
+// of an object, leaving out those set by Flux or others.
+func originAnnotations(annotations map[string]string) map[string]string {
+	origin := map[string]string{}
+	for _, key := range []string{SpaceAnnotationKey, UnitAnnotationKey, UnitIDAnnotationKey, RevisionAnnotationKey, URLAnnotationKey, WorkerAnnotationKey} {
+		if value, ok := annotations[key]; ok {
+			origin[key] = value
+		}
+	}
+	return origin
+}
+
+// commonAnnotations returns the annotations set on every object deployed for a unit.
+// The revision is left out, as it would make every revision rewrite all the objects of
+// the unit, including those which did not change.
+func commonAnnotations(annotations map[string]string) map[string]string {
+	common := maps.Clone(annotations)
+	delete(common, RevisionAnnotationKey)
+	return common
+}
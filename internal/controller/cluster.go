@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClusterLabelKey is the label marking Secrets in the bridge namespace containing the
+// kubeconfig of a remote cluster. The label value is used as the name of the cluster.
+const ClusterLabelKey = "flux-bridge.confighub.com/cluster"
+
+// Cluster is a remote cluster which Flux can deploy to using a kubeconfig Secret.
+type Cluster struct {
+	Name       string
+	SecretName string
+}
+
+// Clusters returns the remote clusters discovered from the labeled kubeconfig Secrets.
+func (c FluxController) Clusters(ctx context.Context) ([]Cluster, error) {
+	secretList := corev1.SecretList{}
+	err := c.kubeClient.List(ctx, &secretList, client.InNamespace(c.namespace), client.HasLabels{ClusterLabelKey})
+	if err != nil {
+		return nil, err
+	}
+	clusters := []Cluster{}
+	for _, secret := range secretList.Items {
+		name := secret.Labels[ClusterLabelKey]
+		if name == "" {
+			name = secret.Name
+		}
+		clusters = append(clusters, Cluster{Name: name, SecretName: secret.Name})
+	}
+	return clusters, nil
+}
+
+// clusterClient returns a client for the cluster Flux deploys to, which is either the
+// local cluster or the remote cluster in the kubeconfig reference.
+func (c FluxController) clusterClient(ctx context.Context, ref *gotkmeta.KubeConfigReference) (client.Client, error) {
+	if ref == nil {
+		return c.kubeClient, nil
+	}
+	if ref.SecretRef == nil {
+		return nil, fmt.Errorf("only kubeconfig Secret references are supported")
+	}
+	secret, err := c.clusterSecret(ctx, ref.SecretRef.Name)
+	if err != nil {
+		return nil, err
+	}
+	if kubeClient, ok := c.clusterClients.get(*ref.SecretRef, secret.ResourceVersion); ok {
+		return kubeClient, nil
+	}
+	cfg, err := secretKubeConfig(secret, *ref.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := client.New(cfg, client.Options{Scheme: c.kubeClient.Scheme()})
+	if err != nil {
+		return nil, err
+	}
+	c.clusterClients.put(*ref.SecretRef, secret.ResourceVersion, kubeClient)
+	return kubeClient, nil
+}
+
+// clusterClientCache holds the clients of the remote clusters, so the APIs of a cluster
+// are not discovered again by every operation. A client is reused until the kubeconfig
+// Secret it was created from changes.
+type clusterClientCache struct {
+	mu      sync.Mutex
+	clients map[gotkmeta.SecretKeyReference]cachedClusterClient
+}
+
+type cachedClusterClient struct {
+	resourceVersion string
+	client          client.Client
+}
+
+func newClusterClientCache() *clusterClientCache {
+	return &clusterClientCache{
+		clients: map[gotkmeta.SecretKeyReference]cachedClusterClient{},
+	}
+}
+
+// get returns the cached client for the kubeconfig Secret at the resource version.
+// Nothing is cached in a nil cache.
+func (cc *clusterClientCache) get(ref gotkmeta.SecretKeyReference, resourceVersion string) (client.Client, bool) {
+	if cc == nil {
+		return nil, false
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cached, ok := cc.clients[ref]
+	if !ok || cached.resourceVersion != resourceVersion {
+		return nil, false
+	}
+	return cached.client, true
+}
+
+func (cc *clusterClientCache) put(ref gotkmeta.SecretKeyReference, resourceVersion string, kubeClient client.Client) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.clients[ref] = cachedClusterClient{resourceVersion: resourceVersion, client: kubeClient}
+}
+
+// clusterSecret returns the kubeconfig Secret with the name. Only Secrets carrying the
+// cluster label are accepted, so units can't deploy with credentials read from any other
+// Secret in the bridge namespace.
+func (c FluxController) clusterSecret(ctx context.Context, name string) (corev1.Secret, error) {
+	secret := corev1.Secret{}
+	err := c.kubeClient.Get(ctx, client.ObjectKey{Name: name, Namespace: c.namespace}, &secret)
+	if apierrors.IsNotFound(err) {
+		return corev1.Secret{}, fmt.Errorf("kubeconfig Secret %s could not be found in namespace %s", name, c.namespace)
+	}
+	if err != nil {
+		return corev1.Secret{}, err
+	}
+	if _, ok := secret.Labels[ClusterLabelKey]; !ok {
+		return corev1.Secret{}, fmt.Errorf("Secret %s is not a cluster kubeconfig Secret, it must have the %s label", name, ClusterLabelKey)
+	}
+	return secret, nil
+}
+
+// checkKubeConfigSecret verifies that the kubeconfig Secret of the options is a cluster
+// kubeconfig Secret.
+func (c FluxController) checkKubeConfigSecret(ctx context.Context, opts ApplyOptions) error {
+	if opts.KubeConfigSecret == "" {
+		return nil
+	}
+	_, err := c.clusterSecret(ctx, opts.KubeConfigSecret)
+	return err
+}
+
+// kubeConfig reads the kubeconfig from the Secret, using the same default keys as Flux.
+func (c FluxController) kubeConfig(ctx context.Context, ref gotkmeta.SecretKeyReference) (*rest.Config, error) {
+	secret, err := c.clusterSecret(ctx, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	return secretKubeConfig(secret, ref)
+}
+
+func secretKubeConfig(secret corev1.Secret, ref gotkmeta.SecretKeyReference) (*rest.Config, error) {
+	keys := []string{"value", "value.yaml"}
+	if ref.Key != "" {
+		keys = []string{ref.Key}
+	}
+	for _, key := range keys {
+		if data, ok := secret.Data[key]; ok {
+			return clientcmd.RESTConfigFromKubeConfig(data)
+		}
+	}
+	return nil, fmt.Errorf("Secret %s does not contain a kubeconfig in key %v", ref.Name, keys)
+}
@@ -5,22 +5,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"time"
 
 	kstatus "github.com/fluxcd/cli-utils/pkg/kstatus/status"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
 	gotkmeta "github.com/fluxcd/pkg/apis/meta"
 	gotkstorage "github.com/fluxcd/pkg/artifact/storage"
 	"github.com/fluxcd/pkg/runtime/patch"
 	scv1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/confighubai/flux-bridge/internal/metrics"
 )
 
 const (
@@ -28,35 +35,64 @@ const (
 	ControllerName    = "flux-bridge"
 
 	dataFileName = "data.yaml"
-	pollInterval = 2 * time.Second
 )
 
 type FluxController struct {
-	kubeClient client.Client
-	namespace  string
-	storage    *gotkstorage.Storage
+	kubeClient  client.Client
+	namespace   string
+	storage     *gotkstorage.Storage
+	kustWatcher *objectWatcher
+	eaWatcher   *objectWatcher
+	helmWatcher *objectWatcher
+	schemas     *SchemaValidator
+	policy      *Policy
+	tenancy     *Tenancy
+	metrics     *metrics.Recorder
+	log         logr.Logger
+	events      record.EventRecorder
+	locker      Locker
+	// clusterClients is shared by the copies of the controller.
+	clusterClients *clusterClientCache
 }
 
-func NewFluxController(ctx context.Context, storage *gotkstorage.Storage, kubeClient client.Client, namespace string) (FluxController, error) {
+// NewFluxController creates a controller for the given namespace. The Kustomization,
+// External Artifact and HelmRelease informers it starts are stopped when the context
+// is cancelled.
+func NewFluxController(ctx context.Context, storage *gotkstorage.Storage, kubeClient client.WithWatch, namespace string) (FluxController, error) {
 	err := addToScheme(kubeClient.Scheme())
 	if err != nil {
 		return FluxController{}, err
 	}
-	kustList := kcv1.KustomizationList{}
-	err = kubeClient.List(ctx, &kustList, client.InNamespace(namespace))
+	kustWatcher, err := newObjectWatcher(ctx, kubeClient, namespace, &kcv1.Kustomization{}, &kcv1.KustomizationList{})
 	if err != nil {
 		return FluxController{}, err
 	}
-	eaList := scv1.ExternalArtifactList{}
-	err = kubeClient.List(ctx, &eaList, client.InNamespace(namespace))
+	eaWatcher, err := newObjectWatcher(ctx, kubeClient, namespace, &scv1.ExternalArtifact{}, &scv1.ExternalArtifactList{})
 	if err != nil {
 		return FluxController{}, err
 	}
+	// The HelmRelease API is optional, units can only be deployed as HelmReleases when it is installed.
+	var helmWatcher *objectWatcher
+	_, err = kubeClient.RESTMapper().RESTMapping(helmv2.GroupVersion.WithKind(helmv2.HelmReleaseKind).GroupKind(), helmv2.GroupVersion.Version)
+	if err != nil && !apimeta.IsNoMatchError(err) {
+		return FluxController{}, err
+	}
+	if err == nil {
+		helmWatcher, err = newObjectWatcher(ctx, kubeClient, namespace, &helmv2.HelmRelease{}, &helmv2.HelmReleaseList{})
+		if err != nil {
+			return FluxController{}, err
+		}
+	}
 
 	client := FluxController{
-		kubeClient: kubeClient,
-		namespace:  namespace,
-		storage:    storage,
+		kubeClient:     kubeClient,
+		namespace:      namespace,
+		storage:        storage,
+		kustWatcher:    kustWatcher,
+		eaWatcher:      eaWatcher,
+		helmWatcher:    helmWatcher,
+		log:            logr.Discard(),
+		clusterClients: newClusterClientCache(),
 	}
 	return client, nil
 }
@@ -102,27 +138,26 @@ func (c FluxController) Diff(ctx context.Context, name string, data []byte) (boo
 		return true, "External Artifact revision does not match Kustomization last applied revision", nil
 	}
 
-	tmpDir, err := os.MkdirTemp("", "")
+	current, err := c.readArtifactData(ea.Status.Artifact)
 	if err != nil {
 		return false, "", err
 	}
-	defer os.RemoveAll(tmpDir)
-	err = c.storage.CopyToPath(ea.Status.Artifact, dataFileName, filepath.Join(tmpDir, dataFileName))
-	if err != nil {
-		return false, "", err
+	if !bytes.Equal(current, data) {
+		return true, "External Artifact current data does not match expected data", nil
 	}
-	current, err := os.ReadFile(filepath.Join(tmpDir, dataFileName))
+
+	drifts, err := c.clusterDrift(ctx, kust, data)
 	if err != nil {
 		return false, "", err
 	}
-	if !bytes.Equal(current, data) {
-		return true, "External Artifact current data does not match expected data", nil
+	if len(drifts) > 0 {
+		return true, driftMessage(drifts), nil
 	}
 	return false, "No drift detected", nil
 }
 
 // Apply will apply the given configuration using Flux.
-func (c FluxController) Apply(ctx context.Context, name string, revision string, data []byte) error {
+func (c FluxController) Apply(ctx context.Context, name string, revision string, data []byte, opts ApplyOptions) error {
 	if len(data) == 0 {
 		return errors.New("can't apply empty data")
 	}
@@ -132,76 +167,68 @@ func (c FluxController) Apply(ctx context.Context, name string, revision string,
 	if revision == "" {
 		return errors.New("revision can't be empty")
 	}
-
-	ea := scv1.ExternalArtifact{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: scv1.GroupVersion.String(),
-			Kind:       scv1.ExternalArtifactKind,
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: c.namespace,
-			Labels: map[string]string{
-				ManagedByLabelKey: ControllerName,
-			},
-		},
-		Spec:   scv1.ExternalArtifactSpec{},
-		Status: scv1.ExternalArtifactStatus{},
+	err := opts.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid apply options: %w", err)
 	}
-
-	// Write artifact contents.
-	tmpDir, err := os.MkdirTemp("", "")
+	opts, err = c.tenantOptions(opts)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpDir)
-	err = os.WriteFile(filepath.Join(tmpDir, dataFileName), data, 0o644)
+	// Reject invalid data before an artifact is written for it.
+	err = validateData(data, c.schemas)
 	if err != nil {
 		return err
 	}
-	artifact := c.storage.NewArtifactFor("confighub", &ea.ObjectMeta, revision, fmt.Sprintf("%s.tar.gz", revision))
-	err = c.storage.MkdirAll(artifact)
+	err = c.checkPolicy(ctx, data, opts)
 	if err != nil {
 		return err
 	}
-	err = c.storage.Archive(&artifact, tmpDir, nil)
+	err = c.checkKubeConfigSecret(ctx, opts)
 	if err != nil {
 		return err
 	}
 
-	// Patch external artifact and status.
-	err = c.kubeClient.Patch(ctx, &ea, client.Apply, &client.PatchOptions{
-		FieldManager: ControllerName,
-		Force:        ptr.To(true),
-	})
+	// Remember the artifact currently served to roll back to on failure.
+	var previous *servedArtifact
+	if opts.Rollback {
+		previous, err = c.previousArtifact(ctx, name, revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.ensureTenant(ctx, opts)
 	if err != nil {
 		return err
 	}
 
-	ea.TypeMeta = metav1.TypeMeta{
-		APIVersion: scv1.GroupVersion.String(),
-		Kind:       scv1.ExternalArtifactKind,
+	// Refuse to take over the objects of another unit with the same name.
+	_, err = c.Owns(ctx, name, opts.Annotations)
+	if err != nil {
+		return err
 	}
-	ea.ManagedFields = nil
-	ea.Status = scv1.ExternalArtifactStatus{
-		Artifact: &artifact,
-		Conditions: []metav1.Condition{
-			{
-				ObservedGeneration: ea.GetGeneration(),
-				Type:               gotkmeta.ReadyCondition,
-				Status:             metav1.ConditionTrue,
-				LastTransitionTime: metav1.Now(),
-				Reason:             gotkmeta.SucceededReason,
-				Message:            "Artifact is ready",
-			},
-		},
+	_, err = c.migrateLegacy(ctx, name, opts.LegacyName, opts.Annotations)
+	if err != nil {
+		return err
 	}
-	statusOpts := &client.SubResourcePatchOptions{
-		PatchOptions: client.PatchOptions{
-			FieldManager: ControllerName,
-		},
+
+	previousRevision, err := c.servedRevision(ctx, name)
+	if err != nil {
+		return err
 	}
-	err = c.kubeClient.Status().Patch(ctx, &ea, client.Apply, statusOpts)
+	ea, err := c.storeArtifact(ctx, name, revision, opts.Annotations, func(artifact *gotkmeta.Artifact) error {
+		tmpDir, err := os.MkdirTemp("", "")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		err = writeLayout(tmpDir, data, opts.Layout)
+		if err != nil {
+			return fmt.Errorf("could not write artifact: %w", err)
+		}
+		return c.storage.Archive(artifact, tmpDir, nil)
+	})
 	if err != nil {
 		return err
 	}
@@ -218,12 +245,19 @@ func (c FluxController) Apply(ctx context.Context, name string, revision string,
 			Labels: map[string]string{
 				ManagedByLabelKey: ControllerName,
 			},
+			Annotations: opts.Annotations,
 		},
 		Spec: kcv1.KustomizationSpec{
-			Interval: metav1.Duration{Duration: 1 * time.Minute},
-			Timeout:  &metav1.Duration{Duration: 5 * time.Minute},
-			Wait:     true,
-			Prune:    true,
+			Interval:           metav1.Duration{Duration: opts.Interval},
+			Timeout:            &metav1.Duration{Duration: opts.Timeout},
+			Wait:               opts.Wait,
+			Prune:              opts.Prune,
+			Force:              opts.Force,
+			Suspend:            opts.Suspend,
+			TargetNamespace:    opts.TargetNamespace,
+			ServiceAccountName: opts.ServiceAccountName,
+			KubeConfig:         opts.kubeConfigRef(),
+			DependsOn:          opts.kustomizationDependsOn(),
 			SourceRef: kcv1.CrossNamespaceSourceReference{
 				Kind:      scv1.ExternalArtifactKind,
 				Name:      ea.ObjectMeta.Name,
@@ -231,6 +265,13 @@ func (c FluxController) Apply(ctx context.Context, name string, revision string,
 			},
 		},
 	}
+	if opts.RetryInterval > 0 {
+		kust.Spec.RetryInterval = &metav1.Duration{Duration: opts.RetryInterval}
+	}
+	if common := commonAnnotations(opts.Annotations); len(common) > 0 {
+		kust.Spec.CommonMetadata = &kcv1.CommonMetadata{Annotations: common}
+	}
+	desired := kust.DeepCopy()
 	err = c.kubeClient.Patch(ctx, &kust, client.Apply, &client.PatchOptions{
 		FieldManager: ControllerName,
 		Force:        ptr.To(true),
@@ -238,15 +279,55 @@ func (c FluxController) Apply(ctx context.Context, name string, revision string,
 	if err != nil {
 		return err
 	}
-	err = waitForCurrentStatus(ctx, c.kubeClient, kust, revision)
-	if err != nil {
-		return err
-	}
-
-	// Garbage collect the old artifact.
-	_, err = c.storage.GarbageCollect(ctx, *ea.Status.Artifact, 5*time.Second)
-	if err != nil {
-		return err
+	c.logger(ctx).Info("Applied Kustomization", "name", name, "revision", revision, "suspend", opts.Suspend)
+	c.revisionChanged(ctx, &kust, previousRevision, revision)
+	// A suspended Kustomization will never reconcile the new revision.
+	if !opts.Suspend {
+		// Reconcile immediately instead of waiting for the interval.
+		err = c.requestReconcile(ctx, &kust)
+		if err != nil {
+			return err
+		}
+		clusterClient, err := c.clusterClient(ctx, kust.Spec.KubeConfig)
+		if err != nil {
+			return err
+		}
+		progress := newProgressReporter(opts.Progress, clusterClient, c.desiredObjects(clusterClient, kust, data))
+		// The timeout covers the wait for the dependencies and for the Kustomization.
+		waitCtx, waitCancel := context.WithTimeout(ctx, kust.Spec.Timeout.Duration)
+		defer waitCancel()
+		// Nothing is applied before the dependencies are ready, so there is nothing to roll back.
+		err = c.waitForDependencies(waitCtx, c.kustWatcher, kcv1.KustomizationKind, opts, progress)
+		if err != nil {
+			return err
+		}
+		err = c.waitForCurrentStatus(waitCtx, kust, revision, progress)
+		if err != nil && previous != nil {
+			return c.rollback(ctx, &kust, ea, *previous, err, progress, func(previous servedArtifact) error {
+				// The objects are annotated with the revision rolled back to.
+				restored := desired.DeepCopy()
+				restored.Annotations = previous.annotations
+				restored.Spec.CommonMetadata = nil
+				if common := commonAnnotations(previous.annotations); len(common) > 0 {
+					restored.Spec.CommonMetadata = &kcv1.CommonMetadata{Annotations: common}
+				}
+				err := c.kubeClient.Patch(ctx, restored, client.Apply, &client.PatchOptions{
+					FieldManager: ControllerName,
+					Force:        ptr.To(true),
+				})
+				if err != nil {
+					return err
+				}
+				err = c.requestReconcile(ctx, restored)
+				if err != nil {
+					return err
+				}
+				return c.waitForCurrentStatus(ctx, *restored, previous.artifact.Revision, progress)
+			})
+		}
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -263,16 +344,36 @@ func (c *FluxController) Delete(ctx context.Context, name string) error {
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	err = wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
-		err = c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&kust), &kust)
-		if apierrors.IsNotFound(err) {
-			return true, nil
+	if err == nil {
+		c.event(ctx, &kust, corev1.EventTypeNormal, DeletedReason, "Deleted Kustomization")
+	}
+	err = c.kustWatcher.waitFor(ctx, name, func(obj client.Object) (bool, error) {
+		return obj == nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.SupportsHelmRelease() {
+		hr := helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: c.namespace,
+			},
 		}
+		err := c.kubeClient.Delete(ctx, &hr)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			c.event(ctx, &hr, corev1.EventTypeNormal, DeletedReason, "Deleted HelmRelease")
+		}
+		err = c.helmWatcher.waitFor(ctx, name, func(obj client.Object) (bool, error) {
+			return obj == nil, nil
+		})
 		if err != nil {
-			return false, err
+			return err
 		}
-		return false, nil
-	})
+	}
 
 	ea := scv1.ExternalArtifact{
 		ObjectMeta: metav1.ObjectMeta{
@@ -288,6 +389,14 @@ func (c *FluxController) Delete(ctx context.Context, name string) error {
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
+	if err == nil {
+		c.event(ctx, &ea, corev1.EventTypeNormal, DeletedReason, "Deleted External Artifact and its stored artifacts")
+	}
+
+	err = c.deleteBackup(ctx, name)
+	if err != nil {
+		return err
+	}
 
 	if ea.Status.Artifact != nil {
 		_, err := c.storage.RemoveAll(*ea.Status.Artifact)
@@ -298,6 +407,121 @@ func (c *FluxController) Delete(ctx context.Context, name string) error {
 	return nil
 }
 
+// storeArtifact creates a new artifact for the revision in storage, with the contents
+// written by the write function, and points the External Artifact at it.
+func (c FluxController) storeArtifact(ctx context.Context, name, revision string, annotations map[string]string, write func(artifact *gotkmeta.Artifact) error) (scv1.ExternalArtifact, error) {
+	ea := scv1.ExternalArtifact{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: scv1.GroupVersion.String(),
+			Kind:       scv1.ExternalArtifactKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+			Labels: map[string]string{
+				ManagedByLabelKey: ControllerName,
+			},
+			Annotations: annotations,
+		},
+		Spec:   scv1.ExternalArtifactSpec{},
+		Status: scv1.ExternalArtifactStatus{},
+	}
+
+	// Write artifact contents.
+	artifact := c.storage.NewArtifactFor("confighub", &ea.ObjectMeta, revision, fmt.Sprintf("%s.tar.gz", revision))
+	err := c.storage.MkdirAll(artifact)
+	if err != nil {
+		return scv1.ExternalArtifact{}, err
+	}
+	err = write(&artifact)
+	if err != nil {
+		return scv1.ExternalArtifact{}, err
+	}
+	if artifact.Size != nil {
+		c.metrics.RecordArtifactSize(*artifact.Size)
+	}
+
+	// Patch external artifact and status.
+	err = c.kubeClient.Patch(ctx, &ea, client.Apply, &client.PatchOptions{
+		FieldManager: ControllerName,
+		Force:        ptr.To(true),
+	})
+	if err != nil {
+		return scv1.ExternalArtifact{}, err
+	}
+	err = c.patchArtifactStatus(ctx, &ea, artifact)
+	if err != nil {
+		return scv1.ExternalArtifact{}, err
+	}
+	c.logger(ctx).V(1).Info("Stored artifact", "name", name, "revision", revision, "digest", artifact.Digest)
+	c.event(ctx, &ea, corev1.EventTypeNormal, ArtifactPublishedReason, fmt.Sprintf("Published artifact for revision %s with digest %s", revision, artifact.Digest))
+	return ea, nil
+}
+
+// patchArtifactStatus backs up the artifact and points the External Artifact status at it.
+// The backup is best-effort, an artifact which can't be backed up is still published but
+// can't be restored after the storage is lost, until the unit is applied again.
+func (c FluxController) patchArtifactStatus(ctx context.Context, ea *scv1.ExternalArtifact, artifact gotkmeta.Artifact) error {
+	err := c.backupArtifact(ctx, *ea, artifact)
+	if err != nil {
+		c.logger(ctx).Error(err, "Could not back up artifact", "name", ea.Name, "revision", artifact.Revision)
+		c.event(ctx, ea, corev1.EventTypeWarning, BackupFailedReason, fmt.Sprintf("Could not back up artifact for revision %s, it can't be restored if the storage is lost: %s", artifact.Revision, err))
+		// Remove the backup of the previous revision, which no longer matches the artifact.
+		err = c.deleteBackup(ctx, ea.Name)
+		if err != nil {
+			return fmt.Errorf("could not remove outdated artifact backup: %w", err)
+		}
+	}
+	return c.patchStatus(ctx, ea, artifact, metav1.ConditionTrue, gotkmeta.SucceededReason, "Artifact is ready")
+}
+
+// patchStatus sets the artifact and Ready condition of the External Artifact status.
+func (c FluxController) patchStatus(ctx context.Context, ea *scv1.ExternalArtifact, artifact gotkmeta.Artifact, status metav1.ConditionStatus, reason, message string) error {
+	ea.TypeMeta = metav1.TypeMeta{
+		APIVersion: scv1.GroupVersion.String(),
+		Kind:       scv1.ExternalArtifactKind,
+	}
+	ea.ManagedFields = nil
+	// The reconcile request annotation is owned by another field manager.
+	if _, ok := ea.Annotations[gotkmeta.ReconcileRequestAnnotation]; ok {
+		ea.Annotations = maps.Clone(ea.Annotations)
+		delete(ea.Annotations, gotkmeta.ReconcileRequestAnnotation)
+	}
+	ea.Status = scv1.ExternalArtifactStatus{
+		Artifact: &artifact,
+		Conditions: []metav1.Condition{
+			{
+				ObservedGeneration: ea.GetGeneration(),
+				Type:               gotkmeta.ReadyCondition,
+				Status:             status,
+				LastTransitionTime: metav1.Now(),
+				Reason:             reason,
+				Message:            message,
+			},
+		},
+	}
+	statusOpts := &client.SubResourcePatchOptions{
+		PatchOptions: client.PatchOptions{
+			FieldManager: ControllerName,
+		},
+	}
+	return c.kubeClient.Status().Patch(ctx, ea, client.Apply, statusOpts)
+}
+
+// readArtifactData returns the contents of the data file stored in the artifact.
+func (c FluxController) readArtifactData(artifact *gotkmeta.Artifact) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	err = c.storage.CopyToPath(artifact, dataFileName, filepath.Join(tmpDir, dataFileName))
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(tmpDir, dataFileName))
+}
+
 func addToScheme(sc *runtime.Scheme) error {
 	err := scv1.AddToScheme(sc)
 	if err != nil {
@@ -307,6 +531,10 @@ func addToScheme(sc *runtime.Scheme) error {
 	if err != nil {
 		return err
 	}
+	err = helmv2.AddToScheme(sc)
+	if err != nil {
+		return err
+	}
 	err = scv1.AddToScheme(sc)
 	if err != nil {
 		return err
@@ -314,45 +542,71 @@ func addToScheme(sc *runtime.Scheme) error {
 	return nil
 }
 
-func waitForCurrentStatus(ctx context.Context, kubeClient client.Client, kust kcv1.Kustomization, revision string) error {
+// waitForCurrentStatus waits until the Kustomization has reconciled the revision and
+// reached a current status, or the Kustomization timeout is reached.
+func (c FluxController) waitForCurrentStatus(ctx context.Context, kust kcv1.Kustomization, revision string, progress *progressReporter) error {
 	waitCtx, waitCancel := context.WithTimeout(ctx, kust.Spec.Timeout.Duration)
-	defer waitCancel()
-	err := wait.PollUntilContextCancel(waitCtx, pollInterval, true, func(ctx context.Context) (bool, error) {
-		err := kubeClient.Get(ctx, client.ObjectKeyFromObject(&kust), &kust)
-		if err != nil {
-			return false, err
-		}
-		if kust.Status.LastAttemptedRevision != "" && kust.Status.LastAttemptedRevision != revision {
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		progress.run(waitCtx)
+	}()
+	defer func() {
+		waitCancel()
+		<-progressDone
+	}()
+	start := time.Now()
+	err := c.kustWatcher.waitFor(waitCtx, kust.Name, func(obj client.Object) (bool, error) {
+		current, ok := obj.(*kcv1.Kustomization)
+		if !ok {
 			return false, nil
 		}
-		err = isStalled(kust)
-		if err != nil {
-			return false, err
-		}
-		u, err := patch.ToUnstructured(&kust)
-		if err != nil {
-			return false, err
-		}
-		result, err := kstatus.Compute(u)
-		switch result.Status {
-		case kstatus.CurrentStatus:
-			return true, nil
-		case kstatus.InProgressStatus:
+		progress.conditionsChanged(kcv1.KustomizationKind, current.Name, current.Status.Conditions)
+		if !reconcileRequestHandled(&kust, current.Status.ReconcileRequestStatus) {
 			return false, nil
-		case kstatus.UnknownStatus:
+		}
+		if current.Status.LastAttemptedRevision != "" && current.Status.LastAttemptedRevision != revision {
 			return false, nil
-		default:
-			return false, wait.ErrorInterrupted(fmt.Errorf("failed Kustomization status %s", result.Status))
 		}
+		return isCurrent(kcv1.KustomizationKind, current, current.Status.Conditions)
 	})
+	c.metrics.RecordWait(kcv1.KustomizationKind, time.Since(start), err)
+	c.logger(ctx).V(1).Info("Waited for Kustomization", "name", kust.Name, "revision", revision, "duration", time.Since(start), "error", err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func isStalled(kust kcv1.Kustomization) error {
-	for _, cond := range kust.Status.Conditions {
+// isCurrent returns true when the Flux object reached a current status, and an error
+// when it is stalled or failed.
+func isCurrent(kind string, obj client.Object, conditions []metav1.Condition) (bool, error) {
+	err := isStalled(conditions)
+	if err != nil {
+		return false, err
+	}
+	u, err := patch.ToUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+	result, err := kstatus.Compute(u)
+	if err != nil {
+		return false, err
+	}
+	switch result.Status {
+	case kstatus.CurrentStatus:
+		return true, nil
+	case kstatus.InProgressStatus:
+		return false, nil
+	case kstatus.UnknownStatus:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed %s status %s", kind, result.Status)
+	}
+}
+
+func isStalled(conditions []metav1.Condition) error {
+	for _, cond := range conditions {
 		if cond.Type == kstatus.ConditionStalled.String() && cond.Status == metav1.ConditionTrue {
 			return errors.New(cond.Message)
 		}
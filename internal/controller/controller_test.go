@@ -1,22 +1,48 @@
 package controller
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/artifact/config"
 	gotkstorage "github.com/fluxcd/pkg/artifact/storage"
 	scv1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/yaml"
+
+	"github.com/confighubai/flux-bridge/internal/metrics"
 )
 
 func TestController(t *testing.T) {
@@ -41,7 +67,7 @@ func TestController(t *testing.T) {
 					obj = ea
 				}
 			}
-			return c.Patch(ctx, obj, patch, opts...)
+			return handleReconcileRequest(ctx, c, obj, patch, opts...)
 		},
 		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
 			err = c.Get(ctx, key, obj, opts...)
@@ -76,12 +102,19 @@ func TestController(t *testing.T) {
 	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
 	require.NoError(t, err)
 
-	data := []byte("Hello World")
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+data:
+  message: Hello World
+`)
 	name := "foo"
 	version := "dev-1"
 
 	// Initial create.
-	err = ctrl.Apply(t.Context(), name, version, data)
+	err = ctrl.Apply(t.Context(), name, version, data, DefaultApplyOptions())
 	require.NoError(t, err)
 	createdEa := scv1.ExternalArtifact{
 		ObjectMeta: metav1.ObjectMeta{
@@ -94,7 +127,7 @@ func TestController(t *testing.T) {
 	require.Equal(t, version, createdEa.Status.Artifact.Revision)
 	require.Equal(t, "confighub/confighub/foo/dev-1.tar.gz", createdEa.Status.Artifact.Path)
 	require.Equal(t, "http://localhost:8080/confighub/confighub/foo/dev-1.tar.gz", createdEa.Status.Artifact.URL)
-	require.Equal(t, "sha256:4a651b6f42849f48ef468b2a691d19536d4d36f79246d7f341b2ba0db9ee8193", createdEa.Status.Artifact.Digest)
+	require.Equal(t, "sha256:c7141cb909d739fb872dbf949af28a8dfe4a2b52b52972e39fc91ae78faa3045", createdEa.Status.Artifact.Digest)
 
 	tarData, err := os.ReadFile(filepath.Join(dataDir, createdEa.Status.Artifact.Path))
 	require.NoError(t, err)
@@ -108,6 +141,9 @@ func TestController(t *testing.T) {
 	}
 	err = kubeClient.Get(t.Context(), client.ObjectKeyFromObject(&kust), &kust)
 	require.NoError(t, err)
+	require.Equal(t, DefaultInterval, kust.Spec.Interval.Duration)
+	require.Equal(t, DefaultTimeout, kust.Spec.Timeout.Duration)
+	require.Nil(t, kust.Spec.RetryInterval)
 	require.True(t, kust.Spec.Wait)
 	require.True(t, kust.Spec.Prune)
 	expectedSourceRef := kcv1.CrossNamespaceSourceReference{
@@ -118,9 +154,16 @@ func TestController(t *testing.T) {
 	require.Equal(t, expectedSourceRef, kust.Spec.SourceRef)
 
 	// Configuration update.
-	data = []byte("Hello World Updated")
+	data = []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+data:
+  message: Hello World Updated
+`)
 	version = "dev-2"
-	err = ctrl.Apply(t.Context(), name, version, data)
+	err = ctrl.Apply(t.Context(), name, version, data, DefaultApplyOptions())
 	require.NoError(t, err)
 	updatedEa := scv1.ExternalArtifact{
 		ObjectMeta: metav1.ObjectMeta{
@@ -130,9 +173,14 @@ func TestController(t *testing.T) {
 	}
 	err = kubeClient.Get(t.Context(), client.ObjectKeyFromObject(&updatedEa), &updatedEa)
 	require.NoError(t, err)
-	_, err = os.Stat(filepath.Join(dataDir, createdEa.Status.Artifact.Path))
-	require.ErrorIs(t, err, os.ErrNotExist)
-	require.Equal(t, "sha256:25d419ea67c80580c6506127badae0bf630ccb73cf88e22732957b3093284358", updatedEa.Status.Artifact.Digest)
+	require.True(t, storage.ArtifactExist(*createdEa.Status.Artifact))
+	require.Equal(t, "sha256:86da2326f41d57b278e215aa962a888d7427d5f61e34c10510213ec6e60e5cf8", updatedEa.Status.Artifact.Digest)
+
+	// Garbage collect the previous artifact.
+	err = ctrl.GarbageCollect(t.Context(), 0)
+	require.NoError(t, err)
+	require.False(t, storage.ArtifactExist(*createdEa.Status.Artifact))
+	require.True(t, storage.ArtifactExist(*updatedEa.Status.Artifact))
 
 	// Diff current configuration.
 	err = kubeClient.Get(t.Context(), client.ObjectKeyFromObject(&kust), &kust)
@@ -143,6 +191,2158 @@ func TestController(t *testing.T) {
 
 	drift, msg, err := ctrl.Diff(t.Context(), name, data)
 	require.NoError(t, err)
+	require.Equal(t, "Drift detected in 1 object(s): ConfigMap/default/app is missing", msg)
+	require.True(t, drift)
+
+	cm := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Labels: map[string]string{
+				"kustomize.toolkit.fluxcd.io/name":      name,
+				"kustomize.toolkit.fluxcd.io/namespace": namespace,
+			},
+		},
+		Data: map[string]string{
+			"message": "Hello World Updated",
+		},
+	}
+	err = kubeClient.Create(t.Context(), &cm)
+	require.NoError(t, err)
+	drift, msg, err = ctrl.Diff(t.Context(), name, data)
+	require.NoError(t, err)
+	require.Equal(t, "No drift detected", msg)
+	require.False(t, drift)
+
+	// Fields which are not set by the unit are not drift.
+	cm.Annotations = map[string]string{"example.com/owner": "team"}
+	cm.Data["extra"] = "Added by another controller"
+	err = kubeClient.Update(t.Context(), &cm)
+	require.NoError(t, err)
+	drift, msg, err = ctrl.Diff(t.Context(), name, data)
+	require.NoError(t, err)
 	require.Equal(t, "No drift detected", msg)
 	require.False(t, drift)
+
+	cm.Data["message"] = "Hello World Edited"
+	err = kubeClient.Update(t.Context(), &cm)
+	require.NoError(t, err)
+	drift, msg, err = ctrl.Diff(t.Context(), name, data)
+	require.NoError(t, err)
+	require.Equal(t, "Drift detected in 1 object(s): ConfigMap/default/app (/data/message)", msg)
+	require.True(t, drift)
+}
+
+func TestFieldDrift(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		desired  string
+		live     string
+		expected []string
+	}{
+		{
+			name:     "defaults are ignored",
+			desired:  `{"spec":{"replicas":2}}`,
+			live:     `{"spec":{"replicas":2.0,"revisionHistoryLimit":10}}`,
+			expected: []string{},
+		},
+		{
+			name:     "changed field",
+			desired:  `{"spec":{"replicas":2,"template":{"metadata":{"labels":{"app/name":"web"}}}}}`,
+			live:     `{"spec":{"replicas":3,"template":{"metadata":{"labels":{"app/name":"api"}}}}}`,
+			expected: []string{"/spec/replicas", "/spec/template/metadata/labels/app~1name"},
+		},
+		{
+			name:     "added containers are ignored",
+			desired:  `{"containers":[{"name":"web","image":"web:1"}]}`,
+			live:     `{"containers":[{"name":"web","image":"web:1","imagePullPolicy":"Always"},{"name":"sidecar"}]}`,
+			expected: []string{},
+		},
+		{
+			name:     "changed list",
+			desired:  `{"args":["--port","8080"]}`,
+			live:     `{"args":["--port","8080","--debug"]}`,
+			expected: []string{"/args"},
+		},
+		{
+			name:     "missing field",
+			desired:  `{"data":{"key":"value"}}`,
+			live:     `{}`,
+			expected: []string{"/data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			desired := map[string]any{}
+			err := json.Unmarshal([]byte(tt.desired), &desired)
+			require.NoError(t, err)
+			live := map[string]any{}
+			err = json.Unmarshal([]byte(tt.live), &live)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, fieldDrift("", desired, live))
+		})
+	}
+}
+
+func TestLiveState(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kust := &kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: namespace,
+		},
+		Status: kcv1.KustomizationStatus{
+			Inventory: &kcv1.ResourceInventory{
+				Entries: []kcv1.ResourceRef{
+					{ID: "default_app__ConfigMap", Version: "v1"},
+					{ID: "default_removed__ConfigMap", Version: "v1"},
+					{ID: "default_token__Secret", Version: "v1"},
+					{ID: "confighub_credentials__Secret", Version: "v1"},
+				},
+			},
+		},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Labels: map[string]string{
+				"kustomize.toolkit.fluxcd.io/name": "foo",
+			},
+		},
+		Data: map[string]string{
+			"key": "value",
+		},
+	}
+	// Secrets outside the bridge namespace can't be read.
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "token",
+			Namespace: "default",
+		},
+	}
+	// The values of Secrets which can be read are redacted.
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "credentials",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"password": []byte("secret"),
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&kcv1.Kustomization{}).
+		WithObjects(kust, cm, secret, credentials).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if u, ok := obj.(*unstructured.Unstructured); ok && u.GetKind() == "Secret" && key.Namespace != namespace {
+					return kerrors.NewForbidden(corev1.Resource("secrets"), key.Name, fmt.Errorf("access denied"))
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, namespace)
+	require.NoError(t, err)
+
+	liveState, err := ctrl.LiveState(t.Context(), "foo")
+	require.NoError(t, err)
+	expected := `apiVersion: v1
+data:
+  key: value
+kind: ConfigMap
+metadata:
+  labels:
+    kustomize.toolkit.fluxcd.io/name: foo
+  name: app
+  namespace: default
+---
+apiVersion: v1
+data:
+  password: '**redacted**'
+kind: Secret
+metadata:
+  name: credentials
+  namespace: confighub
+`
+	require.Equal(t, expected, string(liveState))
+	require.NotContains(t, string(liveState), base64.StdEncoding.EncodeToString([]byte("secret")))
+
+	liveState, err = ctrl.LiveState(t.Context(), "missing")
+	require.NoError(t, err)
+	require.Empty(t, liveState)
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kust := &kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: namespace,
+		},
+		Spec: kcv1.KustomizationSpec{
+			SourceRef: kcv1.CrossNamespaceSourceReference{
+				Kind: scv1.GitRepositoryKind,
+				Name: "flux-system",
+			},
+		},
+		Status: kcv1.KustomizationStatus{
+			Inventory: &kcv1.ResourceInventory{
+				Entries: []kcv1.ResourceRef{
+					{ID: "default_app__ConfigMap", Version: "v1"},
+				},
+			},
+		},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Labels: map[string]string{
+				"app":                                   "app",
+				"kustomize.toolkit.fluxcd.io/name":      "existing",
+				"kustomize.toolkit.fluxcd.io/namespace": namespace,
+			},
+		},
+		Data: map[string]string{
+			"key": "value",
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&kcv1.Kustomization{}).
+		WithObjects(kust, cm).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, namespace)
+	require.NoError(t, err)
+
+	ctx := ContextWithOrigin(t.Context(), Origin{Space: "apps", Unit: "existing"})
+	data, liveState, err := ctrl.Import(ctx, "existing", ImportOptions{})
+	require.NoError(t, err)
+	expectedData := `apiVersion: v1
+data:
+  key: value
+kind: ConfigMap
+metadata:
+  labels:
+    app: app
+  name: app
+  namespace: default
+`
+	require.Equal(t, expectedData, string(data))
+	require.Contains(t, string(liveState), "kustomize.toolkit.fluxcd.io/name: existing")
+
+	// The imported Kustomization is only changed when it is suspended.
+	err = kubeClient.Get(t.Context(), client.ObjectKeyFromObject(kust), kust)
+	require.NoError(t, err)
+	require.False(t, kust.Spec.Suspend)
+	require.Empty(t, kust.Annotations)
+
+	// A suspended Kustomization stops deploying its source.
+	_, _, err = ctrl.Import(ctx, "existing", ImportOptions{Suspend: true})
+	require.NoError(t, err)
+	err = kubeClient.Get(t.Context(), client.ObjectKeyFromObject(kust), kust)
+	require.NoError(t, err)
+	require.True(t, kust.Spec.Suspend)
+	require.Equal(t, "apps", kust.Annotations[SpaceAnnotationKey])
+	require.Equal(t, "existing", kust.Annotations[UnitAnnotationKey])
+
+	_, _, err = ctrl.Import(t.Context(), "missing", ImportOptions{})
+	require.EqualError(t, err, "Kustomization missing could not be found in namespace confighub")
+}
+
+func TestNewObjectWatcher(t *testing.T) {
+	t.Parallel()
+
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				return kerrors.NewForbidden(kcv1.GroupVersion.WithResource("kustomizations").GroupResource(), "", fmt.Errorf("access denied"))
+			},
+		}).
+		Build()
+
+	// A missing permission fails immediately instead of waiting for the cache to sync.
+	_, err = NewFluxController(t.Context(), nil, kubeClient, "confighub")
+	require.ErrorContains(t, err, "could not list *v1.Kustomization in namespace confighub")
+	require.True(t, kerrors.IsForbidden(err))
+}
+
+func TestWaitForCurrentStatus(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kust := &kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "foo",
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: kcv1.KustomizationSpec{
+			Timeout: &metav1.Duration{Duration: 200 * time.Millisecond},
+		},
+		Status: kcv1.KustomizationStatus{
+			ObservedGeneration:    1,
+			LastAttemptedRevision: "1",
+			Conditions: []metav1.Condition{
+				{
+					Type:   gotkmeta.ReadyCondition,
+					Status: metav1.ConditionUnknown,
+					Reason: gotkmeta.ProgressingReason,
+				},
+				{
+					Type:   gotkmeta.ReconcilingCondition,
+					Status: metav1.ConditionTrue,
+					Reason: gotkmeta.ProgressingReason,
+				},
+			},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&kcv1.Kustomization{}).
+		WithObjects(kust).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, namespace)
+	require.NoError(t, err)
+
+	// Times out while the Kustomization is reconciling.
+	err = ctrl.waitForCurrentStatus(t.Context(), *kust, "1", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Returns once the Kustomization becomes ready.
+	kust.Spec.Timeout = &metav1.Duration{Duration: 5 * time.Second}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		updated := kust.DeepCopy()
+		updated.Status.Conditions = []metav1.Condition{
+			{
+				Type:   gotkmeta.ReadyCondition,
+				Status: metav1.ConditionTrue,
+				Reason: gotkmeta.ReconciliationSucceededReason,
+			},
+		}
+		assert.NoError(t, kubeClient.Status().Update(context.Background(), updated))
+	}()
+	msgs := []string{}
+	progress := newProgressReporter(func(msg string) {
+		msgs = append(msgs, msg)
+	}, kubeClient, nil)
+	err = ctrl.waitForCurrentStatus(t.Context(), *kust, "1", progress)
+	require.NoError(t, err)
+	expectedMsgs := []string{
+		"Kustomization foo: Reconciling=True (Progressing); Ready=Unknown (Progressing)",
+		"Kustomization foo: Ready=True (ReconciliationSucceeded)",
+	}
+	require.Equal(t, expectedMsgs, msgs)
+
+	// Delete waits for the Kustomization to be removed.
+	err = ctrl.Delete(t.Context(), "foo")
+	require.NoError(t, err)
+	obj, err := ctrl.kustWatcher.get("foo")
+	require.NoError(t, err)
+	require.Nil(t, obj)
+}
+
+func TestProgressReporterObjects(t *testing.T) {
+	t.Parallel()
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "app",
+			Namespace:  "default",
+			Generation: 1,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(int32(2)),
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			Replicas:           2,
+			UpdatedReplicas:    2,
+			AvailableReplicas:  1,
+			ReadyReplicas:      1,
+		},
+	}
+	gets := 0
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithStatusSubresource(&appsv1.Deployment{}).
+		WithObjects(deploy).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				gets++
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+
+	desired := &unstructured.Unstructured{}
+	desired.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	desired.SetName("app")
+	desired.SetNamespace("default")
+	msgs := []string{}
+	progress := newProgressReporter(func(msg string) {
+		msgs = append(msgs, msg)
+	}, kubeClient, []*unstructured.Unstructured{desired})
+
+	progress.checkObjects(t.Context())
+	progress.checkObjects(t.Context())
+	deploy.Status.AvailableReplicas = 2
+	deploy.Status.ReadyReplicas = 2
+	deploy.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentAvailable,
+		Status: corev1.ConditionTrue,
+	}}
+	err := kubeClient.Status().Update(t.Context(), deploy)
+	require.NoError(t, err)
+	progress.checkObjects(t.Context())
+	require.Equal(t, 3, gets)
+
+	// Ready objects are not fetched again.
+	progress.checkObjects(t.Context())
+	require.Equal(t, 3, gets)
+
+	expectedMsgs := []string{
+		"Waiting for 1 object(s) to become ready: Deployment/default/app (InProgress: Available: 1/2)",
+		"Deployment/default/app is Current",
+	}
+	require.Equal(t, expectedMsgs, msgs)
+}
+
+func TestApplyRollback(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+
+	// Simulate kustomize-controller, failing to reconcile revision 2.
+	reconcile := func(ctx context.Context, c client.Client) error {
+		ea := scv1.ExternalArtifact{}
+		err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+		if err != nil || ea.Status.Artifact == nil {
+			return client.IgnoreNotFound(err)
+		}
+		kust := kcv1.Kustomization{}
+		err = c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &kust)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		kust.Status.ObservedGeneration = kust.Generation
+		kust.Status.LastAttemptedRevision = ea.Status.Artifact.Revision
+		kust.Status.LastHandledReconcileAt = kust.Annotations[gotkmeta.ReconcileRequestAnnotation]
+		kust.Status.Conditions = []metav1.Condition{
+			{
+				Type:   gotkmeta.ReadyCondition,
+				Status: metav1.ConditionTrue,
+				Reason: gotkmeta.ReconciliationSucceededReason,
+			},
+		}
+		if ea.Status.Artifact.Revision == "2" {
+			kust.Status.Conditions = []metav1.Condition{
+				{
+					Type:    gotkmeta.ReadyCondition,
+					Status:  metav1.ConditionFalse,
+					Reason:  gotkmeta.ReconciliationFailedReason,
+					Message: "ConfigMap/default/app dry-run failed",
+				},
+				{
+					Type:    gotkmeta.StalledCondition,
+					Status:  metav1.ConditionTrue,
+					Reason:  gotkmeta.ReconciliationFailedReason,
+					Message: "ConfigMap/default/app dry-run failed",
+				},
+			}
+		}
+		return c.Status().Update(ctx, &kust)
+	}
+	int := interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			err := c.Patch(ctx, obj, patch, opts...)
+			if err != nil {
+				return err
+			}
+			if _, ok := obj.(*kcv1.Kustomization); ok {
+				return reconcile(ctx, c)
+			}
+			return nil
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			err := c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+			if err != nil {
+				return err
+			}
+			return reconcile(ctx, c)
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(int).
+		Build()
+	dataDir := t.TempDir()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    dataDir,
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(20)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithEventRecorder(recorder)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	opts := DefaultApplyOptions()
+	opts.Rollback = true
+	opts.Annotations = Origin{Space: "apps", Unit: "foo", Revision: 1, Worker: "worker-1"}.Annotations()
+	err = ctrl.Apply(t.Context(), name, "1", data, opts)
+	require.NoError(t, err)
+
+	msgs := []string{}
+	opts.Progress = func(msg string) {
+		msgs = append(msgs, msg)
+	}
+	opts.Annotations = Origin{Space: "apps", Unit: "foo", Revision: 2, Worker: "worker-2"}.Annotations()
+	err = ctrl.Apply(t.Context(), name, "2", data, opts)
+	rollbackErr := &RollbackError{}
+	require.ErrorAs(t, err, &rollbackErr)
+	require.Equal(t, "1", rollbackErr.Revision)
+	require.EqualError(t, err, "ConfigMap/default/app dry-run failed, rolled back to revision 1")
+	require.Contains(t, msgs, "Apply failed, rolling back to revision 1: ConfigMap/default/app dry-run failed")
+
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, "1", ea.Status.Artifact.Revision)
+	require.True(t, storage.ArtifactExist(*ea.Status.Artifact))
+	_, err = os.Stat(filepath.Join(dataDir, "confighub/confighub/foo/2.tar.gz"))
+	require.NoError(t, err)
+
+	// The annotations of the revision rolled back to are restored.
+	require.Equal(t, "1", ea.Annotations[RevisionAnnotationKey])
+	require.Equal(t, "worker-1", ea.Annotations[WorkerAnnotationKey])
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	require.Equal(t, "1", kust.Annotations[RevisionAnnotationKey])
+	require.Equal(t, "worker-1", kust.Annotations[WorkerAnnotationKey])
+	require.Equal(t, commonAnnotations(Origin{Space: "apps", Unit: "foo", Revision: 1, Worker: "worker-1"}.Annotations()), kust.Spec.CommonMetadata.Annotations)
+
+	close(recorder.Events)
+	rolledBack := []string{}
+	for event := range recorder.Events {
+		if strings.HasPrefix(event, "Warning") {
+			rolledBack = append(rolledBack, event)
+		}
+	}
+	require.Equal(t, []string{
+		"Warning RolledBack Rolled back to revision 1: ConfigMap/default/app dry-run failed",
+		"Warning RolledBack Rolled back to revision 1: ConfigMap/default/app dry-run failed",
+	}, rolledBack)
+}
+
+func TestGarbageCollectMaxSize(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:              t.TempDir(),
+		StorageAddress:           ":8080",
+		ArtifactRetentionTTL:     time.Hour,
+		ArtifactRetentionRecords: 10,
+	})
+	require.NoError(t, err)
+
+	ea := &scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: namespace,
+			Labels: map[string]string{
+				ManagedByLabelKey: ControllerName,
+			},
+		},
+	}
+	artifacts := []gotkmeta.Artifact{}
+	for i, revision := range []string{"1", "2", "3"} {
+		artifact := storage.NewArtifactFor("confighub", &ea.ObjectMeta, revision, revision+".tar.gz")
+		require.NoError(t, storage.MkdirAll(artifact))
+		path := storage.LocalPath(artifact)
+		require.NoError(t, os.WriteFile(path, make([]byte, 100), 0o644))
+		modTime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		artifacts = append(artifacts, artifact)
+	}
+	ea.Status.Artifact = &artifacts[2]
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}).
+		WithObjects(ea).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	// All artifacts are within the retention policy.
+	err = ctrl.GarbageCollect(t.Context(), 0)
+	require.NoError(t, err)
+	for _, artifact := range artifacts {
+		require.True(t, storage.ArtifactExist(artifact))
+	}
+
+	// Units with a running operation are skipped.
+	locker := fakeLocker{ea.Name: true}
+	err = ctrl.WithLocker(locker).GarbageCollect(t.Context(), 250)
+	require.NoError(t, err)
+	require.True(t, storage.ArtifactExist(artifacts[0]))
+
+	// The oldest artifact is removed to fit within the max size.
+	delete(locker, ea.Name)
+	err = ctrl.WithLocker(locker).GarbageCollect(t.Context(), 250)
+	require.NoError(t, err)
+	require.False(t, storage.ArtifactExist(artifacts[0]))
+	require.True(t, storage.ArtifactExist(artifacts[1]))
+	require.True(t, storage.ArtifactExist(artifacts[2]))
+
+	// The current artifact is kept even when it exceeds the max size.
+	err = ctrl.GarbageCollect(t.Context(), 50)
+	require.NoError(t, err)
+	require.False(t, storage.ArtifactExist(artifacts[1]))
+	require.True(t, storage.ArtifactExist(artifacts[2]))
+}
+
+type fakeLocker map[string]bool
+
+func (l fakeLocker) TryLock(name string, _ map[string]string) (func(), bool) {
+	if l[name] {
+		return nil, false
+	}
+	return func() {}, true
+}
+
+func TestRehydrate(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	err = ctrl.Apply(t.Context(), name, "1", data, DefaultApplyOptions())
+	require.NoError(t, err)
+
+	// Restart with empty storage.
+	restartedStorage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	restartedCtrl, err := NewFluxController(t.Context(), restartedStorage, kubeClient, namespace)
+	require.NoError(t, err)
+	err = restartedCtrl.Rehydrate(t.Context())
+	require.NoError(t, err)
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.NoError(t, restartedStorage.VerifyArtifact(*ea.Status.Artifact))
+	require.Equal(t, metav1.ConditionTrue, ea.Status.Conditions[0].Status)
+	restored, err := restartedCtrl.readArtifactData(ea.Status.Artifact)
+	require.NoError(t, err)
+	require.Equal(t, data, restored)
+
+	// Without a backup the External Artifact stays not ready.
+	err = restartedCtrl.deleteBackup(t.Context(), name)
+	require.NoError(t, err)
+	err = restartedStorage.Remove(*ea.Status.Artifact)
+	require.NoError(t, err)
+	err = restartedCtrl.Rehydrate(t.Context())
+	require.NoError(t, err)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, metav1.ConditionFalse, ea.Status.Conditions[0].Status)
+	require.Equal(t, ArtifactMissingReason, ea.Status.Conditions[0].Reason)
+	require.Equal(t, "Artifact could not be restored, apply the unit again to recreate it: no backup exists", ea.Status.Conditions[0].Message)
+}
+
+func TestBackupOverCap(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithEventRecorder(recorder)
+
+	err = ctrl.Apply(t.Context(), name, "1", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`), DefaultApplyOptions())
+	require.NoError(t, err)
+	secret := corev1.Secret{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: backupNamePrefix + name, Namespace: namespace}, &secret)
+	require.NoError(t, err)
+
+	// Random data does not compress, so the artifact exceeds the backup size.
+	random := make([]byte, backupSecretDataCap)
+	_, err = rand.NewChaCha8([32]byte{}).Read(random)
+	require.NoError(t, err)
+	data := fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+binaryData:
+  random: %s
+`, base64.StdEncoding.EncodeToString(random))
+	err = ctrl.Apply(t.Context(), name, "2", []byte(data), DefaultApplyOptions())
+	require.NoError(t, err)
+
+	// The artifact is published without a backup, and the outdated backup is removed.
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, "2", ea.Status.Artifact.Revision)
+	require.Equal(t, metav1.ConditionTrue, ea.Status.Conditions[0].Status)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: backupNamePrefix + name, Namespace: namespace}, &secret)
+	require.True(t, kerrors.IsNotFound(err))
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	require.Contains(t, events, fmt.Sprintf("Warning BackupFailed Could not back up artifact for revision 2, it can't be restored if the storage is lost: artifact size %d exceeds the maximum backup size of %d bytes", *ea.Status.Artifact.Size, backupSecretDataCap))
+}
+
+func TestHelmRelease(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+
+	manifest := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: podinfo
+data:
+  replicas: "2"
+`
+	release, err := json.Marshal(map[string]string{"manifest": manifest})
+	require.NoError(t, err)
+	buf := bytes.Buffer{}
+	gzw := gzip.NewWriter(&buf)
+	_, err = gzw.Write(release)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	releaseSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sh.helm.release.v1.foo.v1",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"release": []byte(base64.StdEncoding.EncodeToString(buf.Bytes())),
+		},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "podinfo",
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			"replicas": "2",
+		},
+	}
+
+	// Simulate helm-controller releasing the chart.
+	int := interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			err := c.Patch(ctx, obj, patch, opts...)
+			if err != nil {
+				return err
+			}
+			hr, ok := obj.(*helmv2.HelmRelease)
+			if !ok {
+				return nil
+			}
+			hr.Status = helmv2.HelmReleaseStatus{
+				ReconcileRequestStatus: gotkmeta.ReconcileRequestStatus{
+					LastHandledReconcileAt: hr.Annotations[gotkmeta.ReconcileRequestAnnotation],
+				},
+				ObservedGeneration:    hr.Generation,
+				LastAttemptedRevision: "1.0.0+0123456789ab",
+				Conditions: []metav1.Condition{
+					{
+						Type:   gotkmeta.ReadyCondition,
+						Status: metav1.ConditionTrue,
+						Reason: gotkmeta.SucceededReason,
+					},
+				},
+				History: helmv2.Snapshots{
+					{
+						Name:         name,
+						Namespace:    namespace,
+						Version:      1,
+						ChartName:    "podinfo",
+						ChartVersion: "1.0.0",
+					},
+				},
+			}
+			return c.Status().Update(ctx, hr)
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithRESTMapper(testrestmapper.TestOnlyStaticRESTMapper(sc)).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &helmv2.HelmRelease{}).
+		WithObjects(releaseSecret, cm).
+		WithInterceptorFuncs(int).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	require.True(t, ctrl.SupportsHelmRelease())
+
+	pkg := ChartPackage{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: ChartPackageAPIVersion,
+			Kind:       ChartPackageKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "podinfo",
+		},
+		Chart:  packageChart(t, "podinfo", "1.0.0"),
+		Values: &apiextensionsv1.JSON{Raw: []byte(`{"replicas":2}`)},
+	}
+	data, err := yaml.Marshal(pkg)
+	require.NoError(t, err)
+
+	err = ctrl.ApplyHelmRelease(t.Context(), name, "1", data, DefaultApplyOptions())
+	require.NoError(t, err)
+	hr := helmv2.HelmRelease{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &hr)
+	require.NoError(t, err)
+	expectedChartRef := &helmv2.CrossNamespaceSourceReference{
+		Kind:      scv1.ExternalArtifactKind,
+		Name:      name,
+		Namespace: namespace,
+	}
+	require.Equal(t, expectedChartRef, hr.Spec.ChartRef)
+	require.JSONEq(t, `{"replicas":2}`, string(hr.Spec.Values.Raw))
+	require.False(t, hr.Spec.Install.DisableWait)
+
+	drift, msg, err := ctrl.DiffHelmRelease(t.Context(), name, data)
+	require.NoError(t, err)
+	require.Equal(t, "No drift detected", msg)
+	require.False(t, drift)
+
+	pkg.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicas":3}`)}
+	changed, err := yaml.Marshal(pkg)
+	require.NoError(t, err)
+	drift, msg, err = ctrl.DiffHelmRelease(t.Context(), name, changed)
+	require.NoError(t, err)
+	require.Equal(t, "HelmRelease values do not match expected values", msg)
+	require.True(t, drift)
+
+	liveState, err := ctrl.HelmReleaseLiveState(t.Context(), name)
+	require.NoError(t, err)
+	expectedLiveState := `apiVersion: v1
+data:
+  replicas: "2"
+kind: ConfigMap
+metadata:
+  name: podinfo
+  namespace: confighub
+`
+	require.Equal(t, expectedLiveState, string(liveState))
+
+	err = ctrl.Delete(t.Context(), name)
+	require.NoError(t, err)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &hr)
+	require.True(t, kerrors.IsNotFound(err))
+
+	_, _, err = parseChartPackage([]byte("apiVersion: v1\nkind: ConfigMap\n"))
+	require.EqualError(t, err, "expected flux-bridge.confighub.com/v1alpha1 ChartPackage, got v1 ConfigMap")
+}
+
+// packageChart returns a packaged chart archive with a single template.
+func packageChart(t *testing.T, name, version string) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	files := map[string]string{
+		name + "/Chart.yaml":               fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\n", name, version),
+		name + "/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n",
+	}
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		err := tw.WriteHeader(&tar.Header{Name: path, Mode: 0o644, Size: int64(len(files[path]))})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(files[path]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func TestHelmReleaseRollback(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+
+	// Simulate helm-controller, failing to release revision 2.
+	reconcile := func(ctx context.Context, c client.Client) error {
+		ea := scv1.ExternalArtifact{}
+		err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+		if err != nil || ea.Status.Artifact == nil {
+			return client.IgnoreNotFound(err)
+		}
+		hr := helmv2.HelmRelease{}
+		err = c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, &hr)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		hr.Status.ObservedGeneration = hr.Generation
+		hr.Status.LastAttemptedRevision = ea.Status.Artifact.Revision + ".0.0"
+		hr.Status.LastHandledReconcileAt = hr.Annotations[gotkmeta.ReconcileRequestAnnotation]
+		hr.Status.Conditions = []metav1.Condition{
+			{
+				Type:   gotkmeta.ReadyCondition,
+				Status: metav1.ConditionTrue,
+				Reason: gotkmeta.SucceededReason,
+			},
+		}
+		if ea.Status.Artifact.Revision == "2" {
+			hr.Status.Conditions = []metav1.Condition{
+				{
+					Type:    gotkmeta.StalledCondition,
+					Status:  metav1.ConditionTrue,
+					Reason:  helmv2.UpgradeFailedReason,
+					Message: "Helm upgrade failed",
+				},
+			}
+		}
+		return c.Status().Update(ctx, &hr)
+	}
+	int := interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			err := c.Patch(ctx, obj, patch, opts...)
+			if err != nil {
+				return err
+			}
+			if _, ok := obj.(*helmv2.HelmRelease); ok {
+				return reconcile(ctx, c)
+			}
+			return nil
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithRESTMapper(testrestmapper.TestOnlyStaticRESTMapper(sc)).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &helmv2.HelmRelease{}).
+		WithInterceptorFuncs(int).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	opts := DefaultApplyOptions()
+	opts.Rollback = true
+	opts.Timeout = 5 * time.Second
+	chartPackage := func(version, values string) []byte {
+		data, err := yaml.Marshal(ChartPackage{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: ChartPackageAPIVersion,
+				Kind:       ChartPackageKind,
+			},
+			Chart:  packageChart(t, "podinfo", version),
+			Values: &apiextensionsv1.JSON{Raw: []byte(values)},
+		})
+		require.NoError(t, err)
+		return data
+	}
+	opts.Annotations = Origin{Space: "apps", Unit: "podinfo", Revision: 1}.Annotations()
+	err = ctrl.ApplyHelmRelease(t.Context(), name, "1", chartPackage("1.0.0", `{"replicas":2}`), opts)
+	require.NoError(t, err)
+
+	// The values of the failed revision are rolled back with its chart.
+	opts.Annotations = Origin{Space: "apps", Unit: "podinfo", Revision: 2}.Annotations()
+	err = ctrl.ApplyHelmRelease(t.Context(), name, "2", chartPackage("2.0.0", `{"replicas":3}`), opts)
+	rollbackErr := &RollbackError{}
+	require.ErrorAs(t, err, &rollbackErr)
+	require.Equal(t, "1", rollbackErr.Revision)
+	hr := helmv2.HelmRelease{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &hr)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"replicas":2}`, string(hr.Spec.Values.Raw))
+	require.Equal(t, "1", hr.Annotations[RevisionAnnotationKey])
+	require.Equal(t, "1.0.0", hr.Status.LastAttemptedRevision)
+}
+
+func TestRemoteCluster(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: prod
+  context:
+    cluster: prod
+current-context: prod
+`
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "prod-kubeconfig",
+			Namespace: namespace,
+			Labels: map[string]string{
+				ClusterLabelKey: "prod",
+			},
+		},
+		Data: map[string][]byte{
+			"value": []byte(kubeConfig),
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithObjects(secret).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	clusters, err := ctrl.Clusters(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, []Cluster{{Name: "prod", SecretName: "prod-kubeconfig"}}, clusters)
+
+	cfg, err := ctrl.kubeConfig(t.Context(), gotkmeta.SecretKeyReference{Name: "prod-kubeconfig"})
+	require.NoError(t, err)
+	require.Equal(t, "https://prod.example.com", cfg.Host)
+	_, err = ctrl.kubeConfig(t.Context(), gotkmeta.SecretKeyReference{Name: "prod-kubeconfig", Key: "config"})
+	require.EqualError(t, err, "Secret prod-kubeconfig does not contain a kubeconfig in key [config]")
+
+	// The client of the cluster is reused until its kubeconfig Secret changes.
+	ref := &gotkmeta.KubeConfigReference{SecretRef: &gotkmeta.SecretKeyReference{Name: "prod-kubeconfig"}}
+	prodClient, err := ctrl.clusterClient(t.Context(), ref)
+	require.NoError(t, err)
+	cachedClient, err := ctrl.clusterClient(t.Context(), ref)
+	require.NoError(t, err)
+	require.Same(t, prodClient, cachedClient)
+	secret.Data["value"] = []byte(strings.ReplaceAll(kubeConfig, "prod.example.com", "prod2.example.com"))
+	err = kubeClient.Update(t.Context(), secret)
+	require.NoError(t, err)
+	updatedClient, err := ctrl.clusterClient(t.Context(), ref)
+	require.NoError(t, err)
+	require.NotSame(t, prodClient, updatedClient)
+
+	opts := DefaultApplyOptions()
+	opts.KubeConfigSecret = "prod-kubeconfig"
+	opts.Suspend = true
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	err = ctrl.Apply(t.Context(), "foo", "1", data, opts)
+	require.NoError(t, err)
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "foo", Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	expectedKubeConfig := &gotkmeta.KubeConfigReference{
+		SecretRef: &gotkmeta.SecretKeyReference{
+			Name: "prod-kubeconfig",
+		},
+	}
+	require.Equal(t, expectedKubeConfig, kust.Spec.KubeConfig)
+
+	// Only Secrets with the cluster label can be used as kubeconfig.
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "credentials",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"value": []byte(kubeConfig),
+		},
+	}
+	err = kubeClient.Create(t.Context(), other)
+	require.NoError(t, err)
+	opts.KubeConfigSecret = "credentials"
+	err = ctrl.Apply(t.Context(), "bar", "1", data, opts)
+	require.EqualError(t, err, "Secret credentials is not a cluster kubeconfig Secret, it must have the flux-bridge.confighub.com/cluster label")
+	_, err = ctrl.kubeConfig(t.Context(), gotkmeta.SecretKeyReference{Name: "credentials"})
+	require.EqualError(t, err, "Secret credentials is not a cluster kubeconfig Secret, it must have the flux-bridge.confighub.com/cluster label")
+	opts.KubeConfigSecret = "missing"
+	err = ctrl.Apply(t.Context(), "bar", "1", data, opts)
+	require.EqualError(t, err, "kubeconfig Secret missing could not be found in namespace confighub")
+}
+
+func TestDependsOn(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	dep := &kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "apps-crds",
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Status: kcv1.KustomizationStatus{
+			ObservedGeneration: 1,
+			Conditions: []metav1.Condition{
+				{
+					Type:    gotkmeta.ReadyCondition,
+					Status:  metav1.ConditionFalse,
+					Reason:  gotkmeta.ReconciliationFailedReason,
+					Message: "CustomResourceDefinition/foos.example.com dry-run failed",
+				},
+			},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithObjects(dep).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	// Apply reports the dependency it is waiting on instead of the Kustomization timing out.
+	msgs := []string{}
+	opts := DefaultApplyOptions()
+	opts.Timeout = 200 * time.Millisecond
+	opts.DependsOn = []string{"apps-crds"}
+	opts.Progress = func(msg string) {
+		msgs = append(msgs, msg)
+	}
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	err = ctrl.Apply(t.Context(), "apps-operator", "1", data, opts)
+	require.EqualError(t, err, "dependency Kustomization apps-crds is not ready, the new revision will be applied once it is: Ready=False (ReconciliationFailed): CustomResourceDefinition/foos.example.com dry-run failed")
+	require.Equal(t, []string{"Waiting on dependency Kustomization apps-crds: Ready=False (ReconciliationFailed): CustomResourceDefinition/foos.example.com dry-run failed"}, msgs)
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "apps-operator", Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	require.Equal(t, []kcv1.DependencyReference{{Name: "apps-crds"}}, kust.Spec.DependsOn)
+
+	// Waiting returns once the dependency becomes ready.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		updated := dep.DeepCopy()
+		updated.Status.Conditions = []metav1.Condition{
+			{
+				Type:   gotkmeta.ReadyCondition,
+				Status: metav1.ConditionTrue,
+				Reason: gotkmeta.ReconciliationSucceededReason,
+			},
+		}
+		assert.NoError(t, kubeClient.Status().Update(context.Background(), updated))
+	}()
+	// The wait runs through the yield function.
+	yielded := 0
+	opts.Yield = func(wait func() error) error {
+		yielded++
+		return wait()
+	}
+	waitCtx, waitCancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer waitCancel()
+	err = ctrl.waitForDependencies(waitCtx, ctrl.kustWatcher, kcv1.KustomizationKind, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, yielded)
+
+	// Missing dependencies are reported as not found.
+	opts.DependsOn = []string{"apps-missing"}
+	missingCtx, missingCancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer missingCancel()
+	err = ctrl.waitForDependencies(missingCtx, ctrl.kustWatcher, kcv1.KustomizationKind, opts, nil)
+	require.EqualError(t, err, "dependency Kustomization apps-missing is not ready, the new revision will be applied once it is: not found")
+}
+
+func TestSplitLayout(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	opts := DefaultApplyOptions()
+	opts.Suspend = true
+	opts.Layout = LayoutSplit
+	data := []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: app
+data:
+  foo: bar
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: app
+`)
+	err = ctrl.Apply(t.Context(), "foo", "1", data, opts)
+	require.NoError(t, err)
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "foo", Namespace: namespace}, &ea)
+	require.NoError(t, err)
+
+	readFile := func(path string) string {
+		dest := filepath.Join(t.TempDir(), "file")
+		err := storage.CopyToPath(ea.Status.Artifact, path, dest)
+		require.NoError(t, err)
+		b, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		return string(b)
+	}
+	expectedKustomization := `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- cluster/namespace/app.yaml
+- namespaces/app/configmap/app.yaml
+- namespaces/app/deployment.apps/app.yaml
+`
+	require.Equal(t, expectedKustomization, readFile(kustomizationFileName))
+	expectedConfigMap := `apiVersion: v1
+data:
+  foo: bar
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: app
+`
+	require.Equal(t, expectedConfigMap, readFile("namespaces/app/configmap/app.yaml"))
+
+	// The unit data is kept as is to compare it with the unit.
+	require.Equal(t, string(data), readFile(dataFileName))
+}
+
+func TestValidateData(t *testing.T) {
+	t.Parallel()
+
+	schemaDir := t.TempDir()
+	configMapSchema := `{
+  "type": "object",
+  "properties": {
+    "apiVersion": {"type": "string"},
+    "kind": {"type": "string"},
+    "metadata": {"type": "object"},
+    "data": {"type": "object", "additionalProperties": {"type": "string"}}
+  },
+  "additionalProperties": false,
+  "x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+}`
+	err := os.WriteFile(filepath.Join(schemaDir, "configmap-v1.json"), []byte(configMapSchema), 0o644)
+	require.NoError(t, err)
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: foos.example.com
+spec:
+  group: example.com
+  names:
+    kind: Foo
+    plural: foos
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            required: ["replicas"]
+            properties:
+              replicas:
+                type: integer
+                minimum: 0
+`
+	err = os.MkdirAll(filepath.Join(schemaDir, "crds"), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(schemaDir, "crds", "foo.yaml"), []byte(crd), 0o644)
+	require.NoError(t, err)
+	schemas, err := NewSchemaValidator(schemaDir)
+	require.NoError(t, err)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+data:
+  replicas: 1
+---
+just a string
+---
+name: app
+---
+apiVersion: example.com/v1
+kind: Foo
+metadata:
+  name: app
+  namespace: default
+spec:
+  replicas: -1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: default
+unknown: field
+`)
+	err = validateData(data, schemas)
+	verr := &ValidationError{}
+	require.ErrorAs(t, err, &verr)
+	expected := []ObjectError{
+		{Document: 0, Object: "ConfigMap/default/app", Errors: []string{"/data/replicas: expected string, but got number"}},
+		{Document: 1, Errors: []string{"not a Kubernetes object"}},
+		{Document: 2, Errors: []string{"not a Kubernetes object, missing apiVersion, kind, metadata.name"}},
+		{Document: 3, Object: "Foo/default/app", Errors: []string{"/spec/replicas: must be >= 0 but found -1"}},
+		{Document: 4, Object: "ConfigMap/default/app", Errors: []string{"duplicate object, first defined in document 0"}},
+	}
+	require.Equal(t, expected, verr.Objects)
+
+	// Apply rejects invalid data before an artifact is written.
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err = addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithSchemaValidator(schemas)
+	err = ctrl.Apply(t.Context(), "foo", "1", data, DefaultApplyOptions())
+	require.ErrorAs(t, err, &verr)
+	eas := scv1.ExternalArtifactList{}
+	err = kubeClient.List(t.Context(), &eas)
+	require.NoError(t, err)
+	require.Empty(t, eas.Items)
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	err := os.WriteFile(policyPath, []byte(`rules:
+- name: kinds
+  allowedKinds: [ConfigMap, Deployment.apps]
+- name: team-a-namespaces
+  spaces: [team-a]
+  allowedNamespaces: [team-a]
+- name: host-access
+  forbiddenFields: [hostPath, securityContext.privileged]
+- name: replicas
+  expression: "object.kind != 'Deployment' || object.spec.replicas <= 3"
+  message: Deployments can have at most 3 replicas
+- name: expensive
+  spaces: [team-c]
+  expression: "[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(a, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(b, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(c, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(d, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(e, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(f, a + b + c + d + e + f >= 0))))))"
+`), 0o644)
+	require.NoError(t, err)
+	policy, err := LoadPolicy(policyPath)
+	require.NoError(t, err)
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err = addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithRESTMapper(testrestmapper.TestOnlyStaticRESTMapper(sc)).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithPolicy(policy)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: team-a
+spec:
+  replicas: 5
+  template:
+    spec:
+      containers:
+      - name: app
+        securityContext:
+          privileged: true
+      - name: sidecar
+        securityContext:
+          privileged: false
+      volumes:
+      - name: host
+        hostPath:
+          path: /var/run
+`)
+	opts := DefaultApplyOptions()
+	opts.Space = "team-a"
+	err = ctrl.Apply(t.Context(), "team-a-app", "1", data, opts)
+	policyErr := &PolicyError{}
+	require.ErrorAs(t, err, &policyErr)
+	expected := []PolicyViolation{
+		{Rule: "team-a-namespaces", Object: "ConfigMap/default/app", Message: "namespace default is not allowed"},
+		{Rule: "kinds", Object: "ClusterRole/app", Message: "kind ClusterRole.rbac.authorization.k8s.io is not allowed"},
+		{Rule: "host-access", Object: "Deployment/team-a/app", Message: "field spec.template.spec.volumes[0].hostPath is forbidden"},
+		{Rule: "host-access", Object: "Deployment/team-a/app", Message: "field spec.template.spec.containers[0].securityContext.privileged is forbidden"},
+		{Rule: "replicas", Object: "Deployment/team-a/app", Message: "Deployments can have at most 3 replicas"},
+	}
+	require.Equal(t, expected, policyErr.Violations)
+
+	// Rules limited to other spaces are not evaluated, and the target namespace is used.
+	data = []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+`)
+	opts.Space = "team-b"
+	err = ctrl.checkPolicy(t.Context(), data, opts)
+	require.NoError(t, err)
+	opts.Space = "team-a"
+	opts.TargetNamespace = "team-a"
+	err = ctrl.checkPolicy(t.Context(), data, opts)
+	require.NoError(t, err)
+
+	// Expressions are stopped when they exceed the cost limit.
+	opts.Space = "team-c"
+	err = ctrl.checkPolicy(t.Context(), data, opts)
+	require.ErrorAs(t, err, &policyErr)
+	require.Equal(t, []PolicyViolation{
+		{Rule: "expensive", Object: "ConfigMap/team-a/app", Message: "expression could not be evaluated: operation cancelled: actual cost limit exceeded"},
+	}, policyErr.Violations)
+	opts.Space = "team-a"
+
+	// HelmRelease units are rejected where rules apply, as the chart objects can't be checked.
+	chart, err := yaml.Marshal(ChartPackage{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: ChartPackageAPIVersion,
+			Kind:       ChartPackageKind,
+		},
+		Chart: packageChart(t, "podinfo", "1.0.0"),
+	})
+	require.NoError(t, err)
+	err = ctrl.ApplyHelmRelease(t.Context(), "team-a-podinfo", "1", chart, opts)
+	require.ErrorAs(t, err, &policyErr)
+	message := "HelmRelease units are not allowed, the objects rendered from the chart can't be checked before they are deployed"
+	expected = []PolicyViolation{
+		{Rule: "kinds", Object: "HelmRelease/confighub/team-a-podinfo", Message: message},
+		{Rule: "team-a-namespaces", Object: "HelmRelease/confighub/team-a-podinfo", Message: message},
+		{Rule: "host-access", Object: "HelmRelease/confighub/team-a-podinfo", Message: message},
+		{Rule: "replicas", Object: "HelmRelease/confighub/team-a-podinfo", Message: message},
+	}
+	require.Equal(t, expected, policyErr.Violations)
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "team-a-podinfo", Namespace: namespace}, &ea)
+	require.True(t, kerrors.IsNotFound(err))
+
+	// Invalid policies are rejected when loading.
+	err = os.WriteFile(policyPath, []byte(`rules:
+- name: replicas
+  expression: "object.spec.replicas +"
+- name: replicas
+- allowedKinds: [ConfigMap]
+`), 0o644)
+	require.NoError(t, err)
+	_, err = LoadPolicy(policyPath)
+	require.ErrorContains(t, err, "rule replicas has an invalid expression")
+	require.ErrorContains(t, err, "rule replicas is defined more than once")
+	require.ErrorContains(t, err, "rule 2 has no name")
+}
+
+func TestTenancy(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	tenancy, err := NewTenancy("team-{{ .Space }}", "tenant-{{ .Space }}", "admin")
+	require.NoError(t, err)
+	ctrl = ctrl.WithTenancy(tenancy)
+
+	// Units are deployed to the namespace and with the service account of their space.
+	opts := DefaultApplyOptions()
+	opts.Suspend = true
+	opts.Space = "a"
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+`)
+	err = ctrl.Apply(t.Context(), "a-app", "1", data, opts)
+	require.NoError(t, err)
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-app", Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	require.Equal(t, "team-a", kust.Spec.TargetNamespace)
+	require.Equal(t, "tenant-a", kust.Spec.ServiceAccountName)
+
+	ns := corev1.Namespace{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "team-a"}, &ns)
+	require.NoError(t, err)
+	require.Equal(t, "a", ns.Labels[SpaceLabelKey])
+	sa := corev1.ServiceAccount{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "tenant-a", Namespace: namespace}, &sa)
+	require.NoError(t, err)
+	rb := rbacv1.RoleBinding{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "flux-bridge-tenant-a", Namespace: "team-a"}, &rb)
+	require.NoError(t, err)
+	require.Equal(t, "admin", rb.RoleRef.Name)
+	require.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: "tenant-a", Namespace: namespace}}, rb.Subjects)
+
+	// Options escaping the tenant are rejected.
+	opts.TargetNamespace = "kube-system"
+	err = ctrl.Apply(t.Context(), "a-app", "2", data, opts)
+	require.EqualError(t, err, "target namespace kube-system is not allowed, units of space a are deployed to team-a")
+	opts.TargetNamespace = ""
+	opts.ServiceAccountName = "kustomize-controller"
+	err = ctrl.Apply(t.Context(), "a-app", "2", data, opts)
+	require.EqualError(t, err, "service account kustomize-controller is not allowed, units of space a are deployed with tenant-a")
+
+	// Templates resulting in invalid names are rejected.
+	tenancy, err = NewTenancy("team_{{ .Space }}", "tenant-{{ .Space }}", "admin")
+	require.NoError(t, err)
+	_, err = ctrl.WithTenancy(tenancy).tenantOptions(DefaultApplyOptions())
+	require.EqualError(t, err, "space is required for tenant isolation")
+	opts = DefaultApplyOptions()
+	opts.Space = "a"
+	_, err = ctrl.WithTenancy(tenancy).tenantOptions(opts)
+	require.ErrorContains(t, err, `invalid tenant namespace "team_a"`)
+
+	// Spaces can't share a namespace or service account.
+	_, err = NewTenancy("team", "tenant-{{ .Space }}", "admin")
+	require.EqualError(t, err, "tenant namespace template must contain {{ .Space }}, so every space gets its own namespace")
+	_, err = NewTenancy("team-{{ .Space }}", "tenant", "admin")
+	require.EqualError(t, err, "tenant service account template must contain {{ .Space }}, so every space gets its own service account")
+
+	// Reserved namespaces and namespaces not created for the space are not used.
+	tenancy, err = NewTenancy("kube-{{ .Space }}", "tenant-{{ .Space }}", "admin")
+	require.NoError(t, err)
+	opts.Space = "system"
+	_, err = ctrl.WithTenancy(tenancy).tenantOptions(opts)
+	require.EqualError(t, err, "tenant namespace kube-system of space system is reserved")
+	tenancy, err = NewTenancy("{{ .Space }}", "tenant-{{ .Space }}", "admin")
+	require.NoError(t, err)
+	opts.Space = namespace
+	_, err = ctrl.WithTenancy(tenancy).tenantOptions(opts)
+	require.EqualError(t, err, "tenant namespace confighub of space confighub is reserved")
+	err = kubeClient.Create(t.Context(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}})
+	require.NoError(t, err)
+	opts = DefaultApplyOptions()
+	opts.Suspend = true
+	opts.Space = "b"
+	err = ctrl.Apply(t.Context(), "b-app", "1", data, opts)
+	require.EqualError(t, err, "could not create tenant b: namespace team-b already exists and was not created for the space")
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "flux-bridge-tenant-b", Namespace: "team-b"}, &rb)
+	require.True(t, kerrors.IsNotFound(err))
+}
+
+func TestCheckTenancy(t *testing.T) {
+	t.Parallel()
+
+	kubeClient := fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+				if !ok {
+					return c.Create(ctx, obj, opts...)
+				}
+				attrs := review.Spec.ResourceAttributes
+				review.Status.Allowed = attrs.Verb == "bind" && attrs.Resource == "clusterroles" && attrs.Name == "admin"
+				return nil
+			},
+		}).
+		Build()
+	ctrl, err := NewFluxController(t.Context(), nil, kubeClient, "confighub")
+	require.NoError(t, err)
+	require.NoError(t, ctrl.CheckTenancy(t.Context()))
+
+	tenancy, err := NewTenancy("team-{{ .Space }}", "tenant-{{ .Space }}", "admin")
+	require.NoError(t, err)
+	require.NoError(t, ctrl.WithTenancy(tenancy).CheckTenancy(t.Context()))
+	tenancy, err = NewTenancy("team-{{ .Space }}", "tenant-{{ .Space }}", "cluster-admin")
+	require.NoError(t, err)
+	err = ctrl.WithTenancy(tenancy).CheckTenancy(t.Context())
+	require.EqualError(t, err, "the bridge is not allowed to bind tenant cluster role cluster-admin, grant it the bind permission on the cluster role")
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	name := "foo"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	err = ctrl.Reconcile(t.Context(), name, nil)
+	require.True(t, kerrors.IsNotFound(err))
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	err = ctrl.Apply(t.Context(), name, "1", data, DefaultApplyOptions())
+	require.NoError(t, err)
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	applyRequestedAt := kust.Annotations[gotkmeta.ReconcileRequestAnnotation]
+	require.NotEmpty(t, applyRequestedAt)
+	require.Equal(t, applyRequestedAt, kust.Status.LastHandledReconcileAt)
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, applyRequestedAt, ea.Annotations[gotkmeta.ReconcileRequestAnnotation])
+
+	err = ctrl.Reconcile(t.Context(), name, nil)
+	require.NoError(t, err)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	require.NotEqual(t, applyRequestedAt, kust.Annotations[gotkmeta.ReconcileRequestAnnotation])
+	require.Equal(t, kust.Annotations[gotkmeta.ReconcileRequestAnnotation], kust.Status.LastHandledReconcileAt)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: name, Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, kust.Annotations[gotkmeta.ReconcileRequestAnnotation], ea.Annotations[gotkmeta.ReconcileRequestAnnotation])
+
+	opts := DefaultApplyOptions()
+	opts.Suspend = true
+	err = ctrl.Apply(t.Context(), name, "2", data, opts)
+	require.NoError(t, err)
+	err = ctrl.Reconcile(t.Context(), name, nil)
+	require.EqualError(t, err, "Kustomization foo is suspended")
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:              t.TempDir(),
+		StorageAddress:           ":8080",
+		ArtifactRetentionTTL:     time.Hour,
+		ArtifactRetentionRecords: 1,
+	})
+	require.NoError(t, err)
+	recorder := metrics.NewRecorder()
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithMetrics(recorder)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	for _, revision := range []string{"1", "2"} {
+		err = ctrl.Apply(t.Context(), "foo", revision, data, DefaultApplyOptions())
+		require.NoError(t, err)
+	}
+	err = ctrl.GarbageCollect(t.Context(), 0)
+	require.NoError(t, err)
+
+	registry, err := metrics.NewRegistry(append(recorder.Collectors(), metrics.NewStateCollector(ctrl))...)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		count, err := ctrl.ManagedObjects()
+		return err == nil && count[kcv1.KustomizationKind] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			switch {
+			case m.GetCounter() != nil:
+				values[family.GetName()] += m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				values[family.GetName()] += m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				values[family.GetName()] += float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	require.Equal(t, 2.0, values["flux_bridge_artifact_size_bytes"])
+	require.Equal(t, 2.0, values["flux_bridge_wait_duration_seconds"])
+	require.Equal(t, 1.0, values["flux_bridge_artifacts_garbage_collected_total"])
+	require.Equal(t, 1.0, values["flux_bridge_managed_objects"])
+	require.Positive(t, values["flux_bridge_storage_size_bytes"])
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:              t.TempDir(),
+		StorageAddress:           ":8080",
+		ArtifactRetentionTTL:     time.Hour,
+		ArtifactRetentionRecords: 1,
+	})
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(20)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+	ctrl = ctrl.WithEventRecorder(recorder)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	for i, revision := range []string{"1", "2"} {
+		ctx := ContextWithOrigin(t.Context(), Origin{Space: "space", Unit: "unit", Revision: int64(i + 1)})
+		err = ctrl.Apply(ctx, "foo", revision, data, DefaultApplyOptions())
+		require.NoError(t, err)
+	}
+	err = ctrl.GarbageCollect(t.Context(), 0)
+	require.NoError(t, err)
+	err = ctrl.Delete(t.Context(), "foo")
+	require.NoError(t, err)
+	close(recorder.Events)
+
+	events := []string{}
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	require.Len(t, events, 7)
+	require.Regexp(t, `^Normal ArtifactPublished Published artifact for revision 1 with digest sha256:[0-9a-f]+ \(ConfigHub space space, unit unit, revision 1\) `, events[0])
+	require.Equal(t, "Normal RevisionChanged Deploying revision 1 (ConfigHub space space, unit unit, revision 1) map[flux-bridge.confighub.com/revision:1 flux-bridge.confighub.com/space:space flux-bridge.confighub.com/unit:unit]", events[1])
+	require.Regexp(t, `^Normal ArtifactPublished Published artifact for revision 2 `, events[2])
+	require.Equal(t, "Normal RevisionChanged Revision changed from 1 to 2 (ConfigHub space space, unit unit, revision 2) map[flux-bridge.confighub.com/revision:2 flux-bridge.confighub.com/space:space flux-bridge.confighub.com/unit:unit]", events[3])
+	require.Equal(t, "Normal GarbageCollected Removed 1 artifact(s) which are no longer retained", events[4])
+	require.Equal(t, "Normal Deleted Deleted Kustomization", events[5])
+	require.Equal(t, "Normal Deleted Deleted External Artifact and its stored artifacts", events[6])
+}
+
+func TestAnnotations(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: handleReconcileRequest}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	origin := Origin{
+		Space:    "apps",
+		Unit:     "podinfo",
+		UnitID:   "0d7b5a3e-4a3b-4b8e-9f3a-5c2d1e6f7a8b",
+		Revision: 3,
+		URL:      "https://hub.confighub.com/space/1/unit/2",
+		Worker:   "flux-bridge",
+	}
+	expected := map[string]string{
+		SpaceAnnotationKey:    "apps",
+		UnitAnnotationKey:     "podinfo",
+		UnitIDAnnotationKey:   "0d7b5a3e-4a3b-4b8e-9f3a-5c2d1e6f7a8b",
+		RevisionAnnotationKey: "3",
+		URLAnnotationKey:      "https://hub.confighub.com/space/1/unit/2",
+		WorkerAnnotationKey:   "flux-bridge",
+	}
+	require.Equal(t, expected, origin.Annotations())
+	require.Equal(t, map[string]string{SpaceAnnotationKey: "apps"}, Origin{Space: "apps"}.Annotations())
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	opts := DefaultApplyOptions()
+	opts.Annotations = origin.Annotations()
+	err = ctrl.Apply(t.Context(), "apps-podinfo", "3", data, opts)
+	require.NoError(t, err)
+
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "apps-podinfo", Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	for key, value := range expected {
+		require.Equal(t, value, ea.Annotations[key])
+	}
+	kust := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "apps-podinfo", Namespace: namespace}, &kust)
+	require.NoError(t, err)
+	for key, value := range expected {
+		require.Equal(t, value, kust.Annotations[key])
+	}
+	// The revision is not set on the deployed objects, so they only change with the unit.
+	common := maps.Clone(expected)
+	delete(common, RevisionAnnotationKey)
+	require.NotNil(t, kust.Spec.CommonMetadata)
+	require.Equal(t, common, kust.Spec.CommonMetadata.Annotations)
+	require.Empty(t, kust.Spec.CommonMetadata.Labels)
+
+	// The annotations kustomize-controller sets from the common metadata are not drift.
+	kust.Status.LastAppliedRevision = "3"
+	err = kubeClient.Status().Update(t.Context(), &kust)
+	require.NoError(t, err)
+	cm := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Labels: map[string]string{
+				"kustomize.toolkit.fluxcd.io/name":      "apps-podinfo",
+				"kustomize.toolkit.fluxcd.io/namespace": namespace,
+			},
+			Annotations: common,
+		},
+	}
+	err = kubeClient.Create(t.Context(), &cm)
+	require.NoError(t, err)
+	drift, msg, err := ctrl.Diff(t.Context(), "apps-podinfo", data)
+	require.NoError(t, err)
+	require.Equal(t, "No drift detected", msg)
+	require.False(t, drift)
+
+	cm.Annotations = maps.Clone(common)
+	cm.Annotations[WorkerAnnotationKey] = "other"
+	err = kubeClient.Update(t.Context(), &cm)
+	require.NoError(t, err)
+	drift, msg, err = ctrl.Diff(t.Context(), "apps-podinfo", data)
+	require.NoError(t, err)
+	require.Equal(t, "Drift detected in 1 object(s): ConfigMap/default/app (/metadata/annotations/flux-bridge.confighub.com~1worker)", msg)
+	require.True(t, drift)
+}
+
+func TestNaming(t *testing.T) {
+	t.Parallel()
+
+	namespace := "confighub"
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	suspendedOnDelete := map[string]bool{}
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		WithStatusSubresource(&scv1.ExternalArtifact{}, &kcv1.Kustomization{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: handleReconcileRequest,
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if kust, ok := obj.(*kcv1.Kustomization); ok && !suspendedOnDelete[kust.Name] {
+					suspendedOnDelete[kust.Name] = kust.Spec.Suspend
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    t.TempDir(),
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, namespace)
+	require.NoError(t, err)
+
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`)
+	owner := Origin{Space: "a", Unit: "b-c"}.Annotations()
+
+	// Objects created before names had a hash suffix have no annotations.
+	err = ctrl.Apply(t.Context(), "a-b-c", "1", data, DefaultApplyOptions())
+	require.NoError(t, err)
+	name, err := ctrl.ResolveName(t.Context(), "a-b-c-1234abcd", "a-b-c", owner)
+	require.NoError(t, err)
+	require.Equal(t, "a-b-c", name)
+
+	// A dependent applied before the migration depends on the legacy name.
+	opts := DefaultApplyOptions()
+	opts.Suspend = true
+	opts.DependsOn = []string{"a-b-c"}
+	err = ctrl.Apply(t.Context(), "a-d-5678abcd", "1", data, opts)
+	require.NoError(t, err)
+
+	// Applying the unit migrates it to the new name, orphaning the deployed objects.
+	opts = DefaultApplyOptions()
+	opts.Annotations = owner
+	opts.LegacyName = "a-b-c"
+	err = ctrl.Apply(t.Context(), "a-b-c-1234abcd", "2", data, opts)
+	require.NoError(t, err)
+	require.True(t, suspendedOnDelete["a-b-c"])
+	dependent := kcv1.Kustomization{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-d-5678abcd", Namespace: namespace}, &dependent)
+	require.NoError(t, err)
+	require.Equal(t, []kcv1.DependencyReference{{Name: "a-b-c-1234abcd"}}, dependent.Spec.DependsOn)
+	require.True(t, dependent.Spec.Suspend)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-b-c", Namespace: namespace}, &kcv1.Kustomization{})
+	require.True(t, kerrors.IsNotFound(err))
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-b-c", Namespace: namespace}, &scv1.ExternalArtifact{})
+	require.True(t, kerrors.IsNotFound(err))
+	name, err = ctrl.ResolveName(t.Context(), "a-b-c-1234abcd", "a-b-c", owner)
+	require.NoError(t, err)
+	require.Equal(t, "a-b-c-1234abcd", name)
+
+	// Another unit can't take over the objects with the same name.
+	opts.Annotations = Origin{Space: "a-b", Unit: "c"}.Annotations()
+	opts.LegacyName = ""
+	err = ctrl.Apply(t.Context(), "a-b-c-1234abcd", "3", data, opts)
+	conflictErr := &NameConflictError{}
+	require.ErrorAs(t, err, &conflictErr)
+	require.EqualError(t, err, "name a-b-c-1234abcd is already used by unit b-c in space a")
+	ea := scv1.ExternalArtifact{}
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-b-c-1234abcd", Namespace: namespace}, &ea)
+	require.NoError(t, err)
+	require.Equal(t, "2", ea.Status.Artifact.Revision)
+
+	// A legacy name used by another unit is not migrated.
+	opts.LegacyName = "a-b-c-1234abcd"
+	name, err = ctrl.ResolveName(t.Context(), "a-b-c-5678abcd", "a-b-c-1234abcd", opts.Annotations)
+	require.NoError(t, err)
+	require.Equal(t, "a-b-c-5678abcd", name)
+	err = ctrl.Apply(t.Context(), "a-b-c-5678abcd", "1", data, opts)
+	require.NoError(t, err)
+	err = kubeClient.Get(t.Context(), client.ObjectKey{Name: "a-b-c-1234abcd", Namespace: namespace}, &kcv1.Kustomization{})
+	require.NoError(t, err)
+}
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	sc := scheme.Scheme
+	err := addToScheme(sc)
+	require.NoError(t, err)
+	kubeClient := fake.NewClientBuilder().
+		WithScheme(sc).
+		Build()
+	dataDir := t.TempDir()
+	storage, err := gotkstorage.New(&config.Options{
+		StoragePath:    dataDir,
+		StorageAddress: ":8080",
+	})
+	require.NoError(t, err)
+	ctrl, err := NewFluxController(t.Context(), storage, kubeClient, "confighub")
+	require.NoError(t, err)
+
+	require.NoError(t, ctrl.CheckAPI(t.Context()))
+	require.NoError(t, ctrl.CheckStorage())
+	entries, err := os.ReadDir(dataDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, os.Chmod(dataDir, 0o500))
+	t.Cleanup(func() {
+		_ = os.Chmod(dataDir, 0o700)
+	})
+	if os.Geteuid() != 0 {
+		require.ErrorContains(t, ctrl.CheckStorage(), "storage is not writable")
+	}
+}
+
+// handleReconcileRequest patches the object and simulates kustomize-controller
+// handling the reconcile request set on a Kustomization.
+func handleReconcileRequest(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Patch(ctx, obj, patch, opts...)
+	if err != nil {
+		return err
+	}
+	if kust, ok := obj.(*kcv1.Kustomization); ok && kust.Annotations[gotkmeta.ReconcileRequestAnnotation] != "" {
+		kust.Status.LastHandledReconcileAt = kust.Annotations[gotkmeta.ReconcileRequestAnnotation]
+		return c.Status().Update(ctx, kust)
+	}
+	return nil
 }
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	kstatus "github.com/fluxcd/cli-utils/pkg/kstatus/status"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/patch"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// kustomizationDependsOn returns the dependency references of the Kustomization.
+func (o ApplyOptions) kustomizationDependsOn() []kcv1.DependencyReference {
+	deps := []kcv1.DependencyReference{}
+	for _, name := range o.DependsOn {
+		deps = append(deps, kcv1.DependencyReference{Name: name})
+	}
+	return deps
+}
+
+// helmReleaseDependsOn returns the dependency references of the HelmRelease.
+func (o ApplyOptions) helmReleaseDependsOn() []helmv2.DependencyReference {
+	deps := []helmv2.DependencyReference{}
+	for _, name := range o.DependsOn {
+		deps = append(deps, helmv2.DependencyReference{Name: name})
+	}
+	return deps
+}
+
+// waitForDependencies waits until all dependencies of the Flux object are ready,
+// reporting the dependency being waited on. Flux will not reconcile the object
+// before that, so the wait is bounded by the deadline of the context, which is shared
+// with the wait for the object itself. The wait runs through the Yield function of the
+// options, as it only waits on other units.
+func (c FluxController) waitForDependencies(ctx context.Context, watcher *objectWatcher, kind string, opts ApplyOptions, progress *progressReporter) error {
+	if len(opts.DependsOn) == 0 {
+		return nil
+	}
+	return opts.yield(func() error {
+		for _, name := range opts.DependsOn {
+			msg := ""
+			err := watcher.waitFor(ctx, name, func(obj client.Object) (bool, error) {
+				ready, reason, err := dependencyReady(obj)
+				if err != nil {
+					return false, err
+				}
+				if ready {
+					return true, nil
+				}
+				msg = reason
+				progress.report(fmt.Sprintf("Waiting on dependency %s %s: %s", kind, name, reason))
+				return false, nil
+			})
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("dependency %s %s is not ready, the new revision will be applied once it is: %s", kind, name, msg)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dependencyReady returns true when the Flux object has reconciled its latest
+// generation successfully, and otherwise the reason it is not ready.
+func dependencyReady(obj client.Object) (bool, string, error) {
+	if obj == nil {
+		return false, "not found", nil
+	}
+	getter, ok := obj.(interface{ GetConditions() []metav1.Condition })
+	if !ok {
+		return false, "", fmt.Errorf("unexpected dependency type %T", obj)
+	}
+	u, err := patch.ToUnstructured(obj)
+	if err != nil {
+		return false, "", err
+	}
+	result, err := kstatus.Compute(u)
+	if err != nil {
+		return false, "", err
+	}
+	if result.Status == kstatus.CurrentStatus {
+		return true, "", nil
+	}
+	cond := apimeta.FindStatusCondition(getter.GetConditions(), gotkmeta.ReadyCondition)
+	if cond == nil {
+		return false, string(result.Status), nil
+	}
+	reason := fmt.Sprintf("Ready=%s (%s)", cond.Status, cond.Reason)
+	if cond.Message != "" {
+		reason = fmt.Sprintf("%s: %s", reason, cond.Message)
+	}
+	return false, reason, nil
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const kustomizeReconcileAnnotationKey = "kustomize.toolkit.fluxcd.io/reconcile"
+
+// ObjectDrift describes an object which differs from its desired state.
+type ObjectDrift struct {
+	Object string
+	Fields []string
+}
+
+func (d ObjectDrift) String() string {
+	if len(d.Fields) == 0 {
+		return fmt.Sprintf("%s is missing", d.Object)
+	}
+	return fmt.Sprintf("%s (%s)", d.Object, strings.Join(d.Fields, ", "))
+}
+
+// clusterDrift compares the desired objects with the objects in the cluster,
+// returning the objects which are missing and the objects in which fields set by the
+// unit were changed. Only the fields set in the desired objects are compared, so
+// defaults and fields added by other controllers are not reported. The comparison only
+// reads the objects, the bridge does not need write access to the cluster to detect
+// drift. Objects the bridge is not allowed to read are skipped.
+func (c FluxController) clusterDrift(ctx context.Context, kust kcv1.Kustomization, data []byte) ([]ObjectDrift, error) {
+	objs, err := ssautils.ReadObjects(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := c.clusterClient(ctx, kust.Spec.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []ObjectDrift{}
+	for _, obj := range objs {
+		err := prepareDesiredObject(kubeClient, kust, obj)
+		if err != nil {
+			return nil, err
+		}
+		if obj.GetAnnotations()[kustomizeReconcileAnnotationKey] == kcv1.DisabledValue {
+			continue
+		}
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		err = kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), live)
+		if apierrors.IsNotFound(err) {
+			drifts = append(drifts, ObjectDrift{Object: ssautils.FmtUnstructured(obj)})
+			continue
+		}
+		// The bridge only reads Secrets in its own namespace, and custom resources it
+		// was granted access to.
+		if apierrors.IsForbidden(err) {
+			c.logger(ctx).V(1).Info("Skipped drift detection of an object the bridge can't read", "object", ssautils.FmtUnstructured(obj))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if live.GetAnnotations()[kustomizeReconcileAnnotationKey] == kcv1.DisabledValue {
+			continue
+		}
+		fields := objectDrift(obj, live)
+		if len(fields) > 0 {
+			drifts = append(drifts, ObjectDrift{Object: ssautils.FmtUnstructured(obj), Fields: fields})
+		}
+	}
+	return drifts, nil
+}
+
+// objectDrift returns the paths of the fields set in the desired object which differ
+// in the live object. Of the metadata, only the labels and annotations are compared.
+func objectDrift(desired, live *unstructured.Unstructured) []string {
+	desiredFields := maps.Clone(desired.Object)
+	delete(desiredFields, "status")
+	metadata := map[string]any{}
+	if labels := desired.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = desiredFields["metadata"].(map[string]any)["labels"]
+	}
+	if annotations := desired.GetAnnotations(); len(annotations) > 0 {
+		metadata["annotations"] = desiredFields["metadata"].(map[string]any)["annotations"]
+	}
+	desiredFields["metadata"] = metadata
+	// Secrets are stored with their string data encoded into the data.
+	if desired.GetKind() == "Secret" && desired.GroupVersionKind().Group == "" {
+		if stringData, ok := desiredFields["stringData"].(map[string]any); ok {
+			data, _ := desiredFields["data"].(map[string]any)
+			data = maps.Clone(data)
+			if data == nil {
+				data = map[string]any{}
+			}
+			for k, v := range stringData {
+				if s, ok := v.(string); ok {
+					data[k] = base64.StdEncoding.EncodeToString([]byte(s))
+				}
+			}
+			delete(desiredFields, "stringData")
+			desiredFields["data"] = data
+		}
+	}
+	return fieldDrift("", desiredFields, live.Object)
+}
+
+// fieldDrift compares the desired value with the live value, returning the JSON
+// pointers of the fields which differ. Maps are compared by the keys set in the
+// desired map, and lists of maps element by element, so fields added to the live
+// objects are ignored. Other lists must be equal.
+func fieldDrift(path string, desired, live any) []string {
+	switch desired := desired.(type) {
+	case map[string]any:
+		live, ok := live.(map[string]any)
+		if !ok {
+			if len(desired) == 0 {
+				return nil
+			}
+			return []string{path}
+		}
+		fields := []string{}
+		for _, k := range slices.Sorted(maps.Keys(desired)) {
+			fields = append(fields, fieldDrift(path+"/"+jsonPointerEscaper.Replace(k), desired[k], live[k])...)
+		}
+		return fields
+	case []any:
+		live, ok := live.([]any)
+		if !ok || len(live) < len(desired) {
+			return []string{path}
+		}
+		if len(live) > len(desired) && !slices.ContainsFunc(desired, isMap) {
+			return []string{path}
+		}
+		fields := []string{}
+		for i := range desired {
+			fields = append(fields, fieldDrift(fmt.Sprintf("%s/%d", path, i), desired[i], live[i])...)
+		}
+		return fields
+	case nil:
+		return nil
+	default:
+		if !scalarEqual(desired, live) {
+			return []string{path}
+		}
+		return nil
+	}
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func isMap(v any) bool {
+	_, ok := v.(map[string]any)
+	return ok
+}
+
+// scalarEqual compares two scalar values, treating numbers decoded as integers and as
+// floats as equal if they have the same value.
+func scalarEqual(a, b any) bool {
+	af, aNumber := toFloat(a)
+	bf, bNumber := toFloat(b)
+	if aNumber && bNumber {
+		return af == bf
+	}
+	return a == b
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// desiredObjects returns the objects in the data as they will be applied by the
+// Kustomization. Data which can't be parsed results in no objects.
+func (c FluxController) desiredObjects(kubeClient client.Client, kust kcv1.Kustomization, data []byte) []*unstructured.Unstructured {
+	objs, err := ssautils.ReadObjects(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	for _, obj := range objs {
+		err := prepareDesiredObject(kubeClient, kust, obj)
+		if err != nil {
+			return nil
+		}
+	}
+	return objs
+}
+
+// prepareDesiredObject mutates the object in the same way kustomize-controller
+// would before applying it.
+func prepareDesiredObject(kubeClient client.Client, kust kcv1.Kustomization, obj *unstructured.Unstructured) error {
+	if kust.Spec.TargetNamespace != "" {
+		namespaced, err := kubeClient.IsObjectNamespaced(obj)
+		if err != nil {
+			return err
+		}
+		if namespaced {
+			obj.SetNamespace(kust.Spec.TargetNamespace)
+		}
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	// The common metadata overrides the labels and annotations set in the objects.
+	if kust.Spec.CommonMetadata != nil {
+		maps.Copy(labels, kust.Spec.CommonMetadata.Labels)
+		maps.Copy(annotations, kust.Spec.CommonMetadata.Annotations)
+	}
+	labels[kcv1.GroupVersion.Group+"/name"] = kust.Name
+	labels[kcv1.GroupVersion.Group+"/namespace"] = kust.Namespace
+	obj.SetLabels(labels)
+	if len(annotations) > 0 {
+		obj.SetAnnotations(annotations)
+	}
+	return nil
+}
+
+func driftMessage(drifts []ObjectDrift) string {
+	objs := []string{}
+	for _, d := range drifts {
+		objs = append(objs, d.String())
+	}
+	return fmt.Sprintf("Drift detected in %d object(s): %s", len(drifts), strings.Join(objs, "; "))
+}
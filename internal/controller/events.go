@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the events recorded on the External Artifacts and Flux objects.
+const (
+	ArtifactPublishedReason = "ArtifactPublished"
+	RevisionChangedReason   = "RevisionChanged"
+	GarbageCollectedReason  = "GarbageCollected"
+	RolledBackReason        = "RolledBack"
+	RollbackFailedReason    = "RollbackFailed"
+	BackupFailedReason      = "BackupFailed"
+	DeletedReason           = "Deleted"
+)
+
+// Origin identifies the ConfigHub unit revision an operation was requested for.
+type Origin struct {
+	Space    string
+	Unit     string
+	UnitID   string
+	Revision int64
+	// URL links to the unit in ConfigHub.
+	URL string
+	// Worker is the name of the bridge worker performing the operation.
+	Worker string
+}
+
+type originKey struct{}
+
+// ContextWithOrigin returns a context carrying the origin of an operation, which is
+// added to the events recorded while performing it.
+func ContextWithOrigin(ctx context.Context, origin Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// OriginFromContext returns the origin of the operation in the context, if any.
+func OriginFromContext(ctx context.Context) (Origin, bool) {
+	origin, ok := ctx.Value(originKey{}).(Origin)
+	return origin, ok
+}
+
+// WithEventRecorder returns a copy of the controller recording Kubernetes Events on
+// the External Artifacts and Flux objects it changes.
+func (c FluxController) WithEventRecorder(recorder record.EventRecorder) FluxController {
+	c.events = recorder
+	return c
+}
+
+// event records an event on the object, annotated with the origin in the context.
+func (c FluxController) event(ctx context.Context, obj runtime.Object, eventType, reason, msg string) {
+	if c.events == nil {
+		return
+	}
+	var annotations map[string]string
+	if origin, ok := OriginFromContext(ctx); ok {
+		msg = fmt.Sprintf("%s (ConfigHub space %s, unit %s, revision %d)", msg, origin.Space, origin.Unit, origin.Revision)
+		annotations = origin.Annotations()
+	}
+	c.events.AnnotatedEventf(obj, annotations, eventType, reason, "%s", msg)
+}
+
+// revisionChanged records an event on the Flux object when it was given a new revision.
+func (c FluxController) revisionChanged(ctx context.Context, obj runtime.Object, previous, revision string) {
+	switch previous {
+	case revision:
+		return
+	case "":
+		c.event(ctx, obj, corev1.EventTypeNormal, RevisionChangedReason, fmt.Sprintf("Deploying revision %s", revision))
+	default:
+		c.event(ctx, obj, corev1.EventTypeNormal, RevisionChangedReason, fmt.Sprintf("Revision changed from %s to %s", previous, revision))
+	}
+}
+
+// servedRevision returns the revision of the artifact currently referenced by the
+// External Artifact, or an empty string if there is none.
+func (c FluxController) servedRevision(ctx context.Context, name string) (string, error) {
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&ea), &ea)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ea.Status.Artifact == nil {
+		return "", nil
+	}
+	return ea.Status.Artifact.Revision, nil
+}
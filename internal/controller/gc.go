@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gcTimeout is the maximum time spent garbage collecting the artifacts of a single unit.
+const gcTimeout = 30 * time.Second
+
+// RunGarbageCollection periodically removes artifacts which are no longer retained
+// until the context is cancelled. Failures are retried on the next run.
+func (c FluxController) RunGarbageCollection(ctx context.Context, interval time.Duration, maxSize int64) error {
+	if interval <= 0 {
+		return errors.New("garbage collection interval must be greater than zero")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := c.GarbageCollect(ctx, maxSize)
+			if err != nil {
+				c.log.Error(err, "Garbage collection failed")
+			}
+		}
+	}
+}
+
+// GarbageCollect removes the artifacts of all units which are older than the storage
+// retention TTL or exceed the number of retention records. When max size is set the
+// oldest artifacts of a unit are removed until their total size fits within it. The
+// artifact currently referenced by the External Artifact is always kept.
+func (c FluxController) GarbageCollect(ctx context.Context, maxSize int64) error {
+	eaList := scv1.ExternalArtifactList{}
+	err := c.kubeClient.List(ctx, &eaList, client.InNamespace(c.namespace), client.MatchingLabels{ManagedByLabelKey: ControllerName})
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, ea := range eaList.Items {
+		if ea.Status.Artifact == nil {
+			continue
+		}
+		// An operation on the unit may be storing or rolling back to an artifact, so
+		// the unit is collected on the next run.
+		unlock, ok := c.tryLock(ea.Name, ea.Annotations)
+		if !ok {
+			c.log.V(1).Info("Skipped garbage collection of a unit with a running operation", "name", ea.Name)
+			continue
+		}
+		deleted, err := c.storage.GarbageCollect(ctx, *ea.Status.Artifact, gcTimeout)
+		removed := len(deleted)
+		if err == nil && maxSize > 0 {
+			var n int
+			n, err = c.enforceMaxSize(*ea.Status.Artifact, maxSize)
+			removed += n
+		}
+		c.metrics.RecordGarbageCollected(removed)
+		if removed > 0 {
+			c.log.V(1).Info("Garbage collected artifacts", "name", ea.Name, "count", removed)
+			c.event(ctx, &ea, corev1.EventTypeNormal, GarbageCollectedReason, fmt.Sprintf("Removed %d artifact(s) which are no longer retained", removed))
+		}
+		unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Locker locks a unit while an operation runs on it.
+type Locker interface {
+	// TryLock locks the unit owning the objects with the name and annotations if no
+	// operation is running on it, and returns a function unlocking it.
+	TryLock(name string, annotations map[string]string) (func(), bool)
+}
+
+// WithLocker returns a copy of the controller which does not garbage collect the
+// artifacts of units locked by the locker.
+func (c FluxController) WithLocker(locker Locker) FluxController {
+	c.locker = locker
+	return c
+}
+
+func (c FluxController) tryLock(name string, annotations map[string]string) (func(), bool) {
+	if c.locker == nil {
+		return func() {}, true
+	}
+	return c.locker.TryLock(name, annotations)
+}
+
+// enforceMaxSize removes the oldest artifacts next to the current artifact until the
+// total size of the artifacts is below max size, and returns the number removed.
+func (c FluxController) enforceMaxSize(current gotkmeta.Artifact, maxSize int64) (int, error) {
+	currentPath := c.storage.LocalPath(current)
+	type artifactFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	files := []artifactFile{}
+	total := int64(0)
+	err := filepath.WalkDir(filepath.Dir(currentPath), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) == ".lock" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		if path != currentPath {
+			files = append(files, artifactFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(files, func(a, b artifactFile) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	removed := 0
+	for _, f := range files {
+		if total <= maxSize {
+			return removed, nil
+		}
+		err := os.Remove(f.path)
+		if err != nil {
+			return removed, err
+		}
+		_ = os.Remove(f.path + ".lock")
+		total -= f.size
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CheckAPI returns an error when the Kubernetes API can't be reached, or the Flux
+// APIs used by the bridge are not installed or can't be listed in its namespace.
+func (c FluxController) CheckAPI(ctx context.Context) error {
+	lists := []client.ObjectList{
+		&kcv1.KustomizationList{},
+		&scv1.ExternalArtifactList{},
+	}
+	if c.SupportsHelmRelease() {
+		lists = append(lists, &helmv2.HelmReleaseList{})
+	}
+	for _, list := range lists {
+		err := c.kubeClient.List(ctx, list, client.InNamespace(c.namespace), client.Limit(1))
+		if err != nil {
+			return fmt.Errorf("could not list %T: %w", list, err)
+		}
+	}
+	return nil
+}
+
+// CheckStorage returns an error when a file can't be written to the artifact storage.
+func (c FluxController) CheckStorage() error {
+	f, err := os.CreateTemp(c.storage.BasePath, ".healthz-")
+	if err != nil {
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("ok")
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	return f.Close()
+}
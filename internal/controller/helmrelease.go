@@ -0,0 +1,552 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ChartPackageAPIVersion = "flux-bridge.confighub.com/v1alpha1"
+	ChartPackageKind       = "ChartPackage"
+
+	helmReleaseDataKey = "release"
+)
+
+// ChartPackage is the configuration data of a unit deployed as a HelmRelease. It holds
+// a packaged Helm chart together with the values used to install it.
+type ChartPackage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Chart is the packaged chart archive, base64 encoded in YAML.
+	Chart []byte `json:"chart"`
+	// Values are the values used when installing and upgrading the chart.
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+}
+
+// chartMetadata holds the fields read from the Chart.yaml of a packaged chart.
+type chartMetadata struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// parseChartPackage parses and validates the chart package in the data.
+func parseChartPackage(data []byte) (ChartPackage, chartMetadata, error) {
+	pkg := ChartPackage{}
+	err := yaml.UnmarshalStrict(data, &pkg)
+	if err != nil {
+		return ChartPackage{}, chartMetadata{}, err
+	}
+	if pkg.APIVersion != ChartPackageAPIVersion || pkg.Kind != ChartPackageKind {
+		return ChartPackage{}, chartMetadata{}, fmt.Errorf("expected %s %s, got %s %s", ChartPackageAPIVersion, ChartPackageKind, pkg.APIVersion, pkg.Kind)
+	}
+	if len(pkg.Chart) == 0 {
+		return ChartPackage{}, chartMetadata{}, errors.New("chart can't be empty")
+	}
+	meta, err := readChartMetadata(pkg.Chart)
+	if err != nil {
+		return ChartPackage{}, chartMetadata{}, err
+	}
+	return pkg, meta, nil
+}
+
+// readChartMetadata reads the Chart.yaml in the root directory of the packaged chart.
+func readChartMetadata(chart []byte) (chartMetadata, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(chart))
+	if err != nil {
+		return chartMetadata{}, fmt.Errorf("chart is not a packaged chart archive: %w", err)
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return chartMetadata{}, errors.New("chart archive does not contain a Chart.yaml")
+		}
+		if err != nil {
+			return chartMetadata{}, fmt.Errorf("chart is not a packaged chart archive: %w", err)
+		}
+		dir, file := path.Split(path.Clean(hdr.Name))
+		if file != "Chart.yaml" || strings.Count(dir, "/") != 1 {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return chartMetadata{}, err
+		}
+		meta := chartMetadata{}
+		err = yaml.Unmarshal(b, &meta)
+		if err != nil {
+			return chartMetadata{}, fmt.Errorf("invalid Chart.yaml: %w", err)
+		}
+		if meta.Name == "" || meta.Version == "" {
+			return chartMetadata{}, errors.New("Chart.yaml is missing the chart name or version")
+		}
+		return meta, nil
+	}
+}
+
+// SupportsHelmRelease returns true when the HelmRelease API is installed in the cluster.
+func (c FluxController) SupportsHelmRelease() bool {
+	return c.helmWatcher != nil
+}
+
+// ApplyHelmRelease will install or upgrade the chart package in the data using a
+// Flux HelmRelease.
+func (c FluxController) ApplyHelmRelease(ctx context.Context, name string, revision string, data []byte, opts ApplyOptions) error {
+	if len(data) == 0 {
+		return errors.New("can't apply empty data")
+	}
+	if name == "" {
+		return errors.New("name can't be empty")
+	}
+	if revision == "" {
+		return errors.New("revision can't be empty")
+	}
+	if !c.SupportsHelmRelease() {
+		return errors.New("HelmRelease API is not installed in the cluster")
+	}
+	err := opts.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid apply options: %w", err)
+	}
+	opts, err = c.tenantOptions(opts)
+	if err != nil {
+		return err
+	}
+	pkg, meta, err := parseChartPackage(data)
+	if err != nil {
+		return fmt.Errorf("invalid chart package: %w", err)
+	}
+	err = c.checkHelmReleasePolicy(name, opts)
+	if err != nil {
+		return err
+	}
+	err = c.checkKubeConfigSecret(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// Remember the artifact currently served and the spec of the HelmRelease installing
+	// it, which holds its values, to roll back to on failure.
+	var previous *servedArtifact
+	var previousSpec *helmv2.HelmReleaseSpec
+	if opts.Rollback {
+		previous, err = c.previousArtifact(ctx, name, revision)
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			previousSpec, err = c.helmReleaseSpec(ctx, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = c.ensureTenant(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// Refuse to take over the objects of another unit with the same name.
+	_, err = c.Owns(ctx, name, opts.Annotations)
+	if err != nil {
+		return err
+	}
+	// Keep upgrading the Helm release of a HelmRelease migrated from a legacy name.
+	releaseName, err := c.migrateLegacy(ctx, name, opts.LegacyName, opts.Annotations)
+	if err != nil {
+		return err
+	}
+	if releaseName == "" {
+		releaseName, err = c.releaseName(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	previousRevision, err := c.servedRevision(ctx, name)
+	if err != nil {
+		return err
+	}
+	ea, err := c.storeArtifact(ctx, name, revision, opts.Annotations, func(artifact *gotkmeta.Artifact) error {
+		return c.storage.Copy(artifact, bytes.NewReader(pkg.Chart))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Apply the HelmRelease installing the chart from the External Artifact.
+	hr := helmv2.HelmRelease{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: helmv2.GroupVersion.String(),
+			Kind:       helmv2.HelmReleaseKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+			Labels: map[string]string{
+				ManagedByLabelKey: ControllerName,
+			},
+			Annotations: opts.Annotations,
+		},
+		Spec: helmv2.HelmReleaseSpec{
+			ChartRef: &helmv2.CrossNamespaceSourceReference{
+				Kind:      scv1.ExternalArtifactKind,
+				Name:      ea.Name,
+				Namespace: c.namespace,
+			},
+			Interval:           metav1.Duration{Duration: opts.Interval},
+			Timeout:            &metav1.Duration{Duration: opts.Timeout},
+			Suspend:            opts.Suspend,
+			TargetNamespace:    opts.TargetNamespace,
+			ReleaseName:        releaseName,
+			ServiceAccountName: opts.ServiceAccountName,
+			KubeConfig:         opts.kubeConfigRef(),
+			DependsOn:          opts.helmReleaseDependsOn(),
+			Values:             pkg.Values,
+			Install: &helmv2.Install{
+				DisableWait: !opts.Wait,
+			},
+			Upgrade: &helmv2.Upgrade{
+				DisableWait: !opts.Wait,
+				Force:       opts.Force,
+			},
+		},
+	}
+	if common := commonAnnotations(opts.Annotations); len(common) > 0 {
+		hr.Spec.CommonMetadata = &helmv2.CommonMetadata{Annotations: common}
+	}
+	desired := hr.DeepCopy()
+	err = c.kubeClient.Patch(ctx, &hr, client.Apply, &client.PatchOptions{
+		FieldManager: ControllerName,
+		Force:        ptr.To(true),
+	})
+	if err != nil {
+		return err
+	}
+	c.logger(ctx).Info("Applied HelmRelease", "name", name, "revision", revision, "suspend", opts.Suspend)
+	c.revisionChanged(ctx, &hr, previousRevision, revision)
+	// A suspended HelmRelease will never reconcile the new revision.
+	if !opts.Suspend {
+		// Reconcile immediately instead of waiting for the interval.
+		err = c.requestReconcile(ctx, &hr)
+		if err != nil {
+			return err
+		}
+		progress := newProgressReporter(opts.Progress, nil, nil)
+		// The timeout covers the wait for the dependencies and for the HelmRelease.
+		waitCtx, waitCancel := context.WithTimeout(ctx, hr.GetTimeout().Duration)
+		defer waitCancel()
+		err = c.waitForDependencies(waitCtx, c.helmWatcher, helmv2.HelmReleaseKind, opts, progress)
+		if err != nil {
+			return err
+		}
+		err = c.waitForHelmReleaseStatus(waitCtx, hr, meta.Version, progress)
+		if err != nil && previous != nil {
+			return c.rollback(ctx, &hr, ea, *previous, err, progress, func(previous servedArtifact) error {
+				chart, err := os.ReadFile(c.storage.LocalPath(previous.artifact))
+				if err != nil {
+					return err
+				}
+				previousMeta, err := readChartMetadata(chart)
+				if err != nil {
+					return err
+				}
+				// Restore the values and annotations of the previous revision along with
+				// its chart.
+				restored := desired.DeepCopy()
+				restored.Annotations = previous.annotations
+				if previousSpec != nil {
+					restored.Spec = *previousSpec
+				} else {
+					restored.Spec.CommonMetadata = nil
+					if common := commonAnnotations(previous.annotations); len(common) > 0 {
+						restored.Spec.CommonMetadata = &helmv2.CommonMetadata{Annotations: common}
+					}
+				}
+				err = c.kubeClient.Patch(ctx, restored, client.Apply, &client.PatchOptions{
+					FieldManager: ControllerName,
+					Force:        ptr.To(true),
+				})
+				if err != nil {
+					return err
+				}
+				err = c.requestReconcile(ctx, restored)
+				if err != nil {
+					return err
+				}
+				return c.waitForHelmReleaseStatus(ctx, *restored, previousMeta.Version, progress)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helmReleaseSpec returns the spec of the HelmRelease with the name, or nil if it does
+// not exist.
+func (c FluxController) helmReleaseSpec(ctx context.Context, name string) (*helmv2.HelmReleaseSpec, error) {
+	hr := helmv2.HelmRelease{}
+	err := c.kubeClient.Get(ctx, client.ObjectKey{Name: name, Namespace: c.namespace}, &hr)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hr.Spec, nil
+}
+
+// DiffHelmRelease compares the chart package in the data with the chart and values
+// deployed by the HelmRelease.
+func (c FluxController) DiffHelmRelease(ctx context.Context, name string, data []byte) (bool, string, error) {
+	pkg, meta, err := parseChartPackage(data)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid chart package: %w", err)
+	}
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err = c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&hr), &hr)
+	if apierrors.IsNotFound(err) {
+		return true, fmt.Sprintf("HelmRelease %s could not be found", name), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err = c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&ea), &ea)
+	if apierrors.IsNotFound(err) {
+		return true, fmt.Sprintf("External Artifact %s could not be found", name), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if ea.Status.Artifact == nil {
+		return true, "External Artifact status is empty", nil
+	}
+	if !c.storage.ArtifactExist(*ea.Status.Artifact) {
+		return true, "Artifact does not exist on disk", nil
+	}
+	current, err := os.ReadFile(c.storage.LocalPath(*ea.Status.Artifact))
+	if err != nil {
+		return false, "", err
+	}
+	if !bytes.Equal(current, pkg.Chart) {
+		return true, "External Artifact current chart does not match expected chart", nil
+	}
+
+	equal, err := valuesEqual(hr.Spec.Values, pkg.Values)
+	if err != nil {
+		return false, "", err
+	}
+	if !equal {
+		return true, "HelmRelease values do not match expected values", nil
+	}
+	latest := hr.Status.History.Latest()
+	if latest == nil {
+		return true, fmt.Sprintf("HelmRelease %s has not been released", name), nil
+	}
+	if latest.ChartName != meta.Name || !chartVersionMatches(latest.ChartVersion, meta.Version) {
+		return true, fmt.Sprintf("HelmRelease released chart %s does not match expected chart %s", latest.VersionedChartName(), meta.Name+"@"+meta.Version), nil
+	}
+	return false, "No drift detected", nil
+}
+
+// HelmReleaseLiveState returns the objects in the latest release of the HelmRelease as
+// they currently exist in the cluster, serialized as a multi document YAML.
+func (c FluxController) HelmReleaseLiveState(ctx context.Context, name string) ([]byte, error) {
+	objs, err := c.helmReleaseObjects(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return marshalObjects(objs)
+}
+
+// helmReleaseObjects fetches all objects in the manifest of the latest Helm release.
+// Objects which no longer exist in the cluster or which the bridge is not allowed to
+// read are skipped, and a missing HelmRelease or release results in no objects.
+func (c FluxController) helmReleaseObjects(ctx context.Context, name string) ([]*unstructured.Unstructured, error) {
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&hr), &hr)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	latest := hr.Status.History.Latest()
+	if latest == nil {
+		return nil, nil
+	}
+	kubeClient, err := c.clusterClient(ctx, hr.Spec.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := corev1.Secret{}
+	secretName := fmt.Sprintf("sh.helm.release.v1.%s.v%d", latest.Name, latest.Version)
+	err = kubeClient.Get(ctx, client.ObjectKey{Name: secretName, Namespace: hr.GetStorageNamespace()}, &secret)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := decodeReleaseManifest(secret.Data[helmReleaseDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("could not decode Helm release %s: %w", secretName, err)
+	}
+	desired, err := ssautils.ReadObjects(strings.NewReader(manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, obj := range desired {
+		if obj.GetNamespace() == "" {
+			namespaced, err := kubeClient.IsObjectNamespaced(obj)
+			if err != nil {
+				return nil, err
+			}
+			if namespaced {
+				obj.SetNamespace(latest.Namespace)
+			}
+		}
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(obj.GroupVersionKind())
+		err = kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), u)
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cleanObject(u)
+		objs = append(objs, u)
+	}
+	return objs, nil
+}
+
+// decodeReleaseManifest returns the rendered manifest of a release stored by Helm, which
+// is a gzipped JSON document encoded as base64.
+func decodeReleaseManifest(data []byte) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		gzr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return "", err
+		}
+		defer gzr.Close()
+		b, err = io.ReadAll(gzr)
+		if err != nil {
+			return "", err
+		}
+	}
+	release := struct {
+		Manifest string `json:"manifest"`
+	}{}
+	err = json.Unmarshal(b, &release)
+	if err != nil {
+		return "", err
+	}
+	return release.Manifest, nil
+}
+
+// waitForHelmReleaseStatus waits until the HelmRelease has reconciled the chart version
+// and reached a current status, or the HelmRelease timeout is reached.
+func (c FluxController) waitForHelmReleaseStatus(ctx context.Context, hr helmv2.HelmRelease, chartVersion string, progress *progressReporter) error {
+	waitCtx, waitCancel := context.WithTimeout(ctx, hr.GetTimeout().Duration)
+	defer waitCancel()
+	start := time.Now()
+	err := c.helmWatcher.waitFor(waitCtx, hr.Name, func(obj client.Object) (bool, error) {
+		current, ok := obj.(*helmv2.HelmRelease)
+		if !ok {
+			return false, nil
+		}
+		progress.conditionsChanged(helmv2.HelmReleaseKind, current.Name, current.Status.Conditions)
+		if !reconcileRequestHandled(&hr, current.Status.ReconcileRequestStatus) {
+			return false, nil
+		}
+		if current.Status.LastAttemptedRevision != "" && !chartVersionMatches(current.Status.LastAttemptedRevision, chartVersion) {
+			return false, nil
+		}
+		return isCurrent(helmv2.HelmReleaseKind, current, current.Status.Conditions)
+	})
+	c.metrics.RecordWait(helmv2.HelmReleaseKind, time.Since(start), err)
+	c.logger(ctx).V(1).Info("Waited for HelmRelease", "name", hr.Name, "chartVersion", chartVersion, "duration", time.Since(start), "error", err)
+	return err
+}
+
+// chartVersionMatches compares a chart version reported by helm-controller, which may
+// have the artifact digest appended as build metadata, with the expected version.
+func chartVersionMatches(reported, version string) bool {
+	return reported == version || strings.HasPrefix(reported, version+"+")
+}
+
+// valuesEqual compares the values semantically, ignoring formatting and key order.
+func valuesEqual(a, b *apiextensionsv1.JSON) (bool, error) {
+	decode := func(v *apiextensionsv1.JSON) (any, error) {
+		if v == nil || len(v.Raw) == 0 {
+			return map[string]any{}, nil
+		}
+		var out any
+		err := json.Unmarshal(v.Raw, &out)
+		return out, err
+	}
+	av, err := decode(a)
+	if err != nil {
+		return false, err
+	}
+	bv, err := decode(b)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
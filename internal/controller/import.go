@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ImportedReason is the reason of the event recorded when a Kustomization which was not
+// created by the bridge is suspended after it was imported.
+const ImportedReason = "Imported"
+
+// ImportOptions configures how an existing Kustomization is imported.
+type ImportOptions struct {
+	// Suspend suspends a Kustomization which was not created by the bridge once it is
+	// imported, so it does not keep deploying its source next to the unit.
+	Suspend bool
+}
+
+// Import returns the configuration data and live state of an existing Kustomization.
+// The data is read from the artifact when the Kustomization was created by the bridge,
+// otherwise it is reconstructed from the objects in the Kustomization inventory. The
+// Kustomization is left unchanged unless the options suspend it.
+func (c FluxController) Import(ctx context.Context, name string, opts ImportOptions) ([]byte, []byte, error) {
+	kust := kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&kust), &kust)
+	if apierrors.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("Kustomization %s could not be found in namespace %s", name, c.namespace)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if kust.Status.Inventory == nil || len(kust.Status.Inventory.Entries) == 0 {
+		return nil, nil, fmt.Errorf("Kustomization %s has no inventory to import", name)
+	}
+
+	objs, err := c.inventoryObjects(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	liveState, err := marshalObjects(objs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := c.artifactData(ctx, kust)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		for _, obj := range objs {
+			labels := obj.GetLabels()
+			for k := range labels {
+				if strings.HasPrefix(k, kcv1.GroupVersion.Group+"/") {
+					delete(labels, k)
+				}
+			}
+			obj.SetLabels(labels)
+		}
+		data, err = marshalObjects(objs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if opts.Suspend {
+		err = c.suspendImported(ctx, &kust)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not suspend imported Kustomization %s: %w", name, err)
+		}
+	}
+	return data, liveState, nil
+}
+
+// suspendImported suspends the imported Kustomization and annotates it with the unit
+// it was imported into. Suspending it keeps its objects in place when it is deleted.
+func (c FluxController) suspendImported(ctx context.Context, kust *kcv1.Kustomization) error {
+	if kust.Labels[ManagedByLabelKey] == ControllerName {
+		return nil
+	}
+	var annotations map[string]string
+	if origin, ok := OriginFromContext(ctx); ok {
+		annotations = origin.Annotations()
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+		"spec": map[string]any{
+			"suspend": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = c.kubeClient.Patch(ctx, kust, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(ControllerName))
+	if err != nil {
+		return err
+	}
+	c.event(ctx, kust, corev1.EventTypeNormal, ImportedReason, "Suspended after it was imported, delete it once the unit is applied, its objects are kept")
+	return nil
+}
+
+// artifactData returns the data stored in the External Artifact referenced by the
+// Kustomization, or nil if the source is not an artifact stored by the bridge.
+func (c FluxController) artifactData(ctx context.Context, kust kcv1.Kustomization) ([]byte, error) {
+	if kust.Spec.SourceRef.Kind != scv1.ExternalArtifactKind {
+		return nil, nil
+	}
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kust.Spec.SourceRef.Name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&ea), &ea)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ea.Labels[ManagedByLabelKey] != ControllerName || ea.Status.Artifact == nil || !c.storage.ArtifactExist(*ea.Status.Artifact) {
+		return nil, nil
+	}
+	return c.readArtifactData(ea.Status.Artifact)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LayoutSingle stores the unit data as a single file in the artifact.
+	LayoutSingle = "single"
+	// LayoutSplit stores every object of the unit in its own file, grouped by
+	// namespace and kind, next to a generated kustomization.yaml.
+	LayoutSplit = "split"
+
+	kustomizationFileName = "kustomization.yaml"
+)
+
+// writeLayout writes the unit data to the directory which is archived as the artifact.
+// The data file is always written as is, so the artifact can be compared with the
+// unit data. It is not listed in the generated kustomization.yaml of the split layout.
+func writeLayout(dir string, data []byte, layout string) error {
+	err := os.WriteFile(filepath.Join(dir, dataFileName), data, 0o644)
+	if err != nil {
+		return err
+	}
+	if layout != LayoutSplit {
+		return nil
+	}
+
+	objs, err := ssautils.ReadObjects(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resources := []string{}
+	for _, obj := range objs {
+		kind := strings.ToLower(obj.GetKind())
+		if group := obj.GroupVersionKind().Group; group != "" {
+			kind = kind + "." + group
+		}
+		path := filepath.Join("cluster", kind, obj.GetName()+".yaml")
+		if obj.GetNamespace() != "" {
+			path = filepath.Join("namespaces", obj.GetNamespace(), kind, obj.GetName()+".yaml")
+		}
+		// Duplicate objects are rejected when validating the data, so every path is unique.
+		resources = append(resources, path)
+
+		b, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(dir, path), b, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	slices.Sort(resources)
+
+	b, err := yaml.Marshal(map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, kustomizationFileName), b, 0o644)
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/cli-utils/pkg/object"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// LiveState returns the objects in the inventory of the Kustomization as they
+// currently exist in the cluster, serialized as a multi document YAML.
+func (c FluxController) LiveState(ctx context.Context, name string) ([]byte, error) {
+	objs, err := c.inventoryObjects(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return marshalObjects(objs)
+}
+
+// inventoryObjects fetches all objects in the inventory of the Kustomization.
+// Objects which no longer exist in the cluster or which the bridge is not allowed to
+// read are skipped, and a missing Kustomization results in no objects.
+func (c FluxController) inventoryObjects(ctx context.Context, name string) ([]*unstructured.Unstructured, error) {
+	kust := kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&kust), &kust)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kust.Status.Inventory == nil {
+		return nil, nil
+	}
+	kubeClient, err := c.clusterClient(ctx, kust.Spec.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, entry := range kust.Status.Inventory.Entries {
+		objMeta, err := object.ParseObjMetadata(entry.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inventory entry %s: %w", entry.ID, err)
+		}
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(objMeta.GroupKind.WithVersion(entry.Version))
+		err = kubeClient.Get(ctx, client.ObjectKey{Namespace: objMeta.Namespace, Name: objMeta.Name}, u)
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cleanObject(u)
+		objs = append(objs, u)
+	}
+	return objs, nil
+}
+
+// redactedValue replaces the values of Secrets in the live state.
+const redactedValue = "**redacted**"
+
+// cleanObject removes fields managed by the API server which are not part of
+// the desired state of the object, and redacts the values of Secrets so they are
+// never sent to ConfigHub.
+func cleanObject(u *unstructured.Unstructured) {
+	u.SetManagedFields(nil)
+	unstructured.RemoveNestedField(u.Object, "status")
+	for _, field := range []string{"creationTimestamp", "resourceVersion", "generation", "uid", "selfLink", "deletionTimestamp", "deletionGracePeriodSeconds"} {
+		unstructured.RemoveNestedField(u.Object, "metadata", field)
+	}
+	if u.GetKind() == "Secret" && u.GroupVersionKind().Group == "" {
+		for _, field := range []string{"data", "stringData"} {
+			values, ok := u.Object[field].(map[string]any)
+			if !ok {
+				continue
+			}
+			for k := range values {
+				values[k] = redactedValue
+			}
+		}
+	}
+	annotations := u.GetAnnotations()
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+	} else {
+		u.SetAnnotations(annotations)
+	}
+}
+
+func marshalObjects(objs []*unstructured.Unstructured) ([]byte, error) {
+	buf := bytes.Buffer{}
+	for i, u := range objs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(u.Object)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
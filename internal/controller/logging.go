@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+)
+
+// WithLogger returns a copy of the controller logging to the logger when the context
+// of an operation does not carry one.
+func (c FluxController) WithLogger(log logr.Logger) FluxController {
+	c.log = log
+	return c
+}
+
+// logger returns the logger of the operation in the context, which is tagged with the
+// unit it operates on, falling back to the logger of the controller.
+func (c FluxController) logger(ctx context.Context) logr.Logger {
+	log, err := logr.FromContext(ctx)
+	if err != nil {
+		return c.log
+	}
+	return log
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+
+	"github.com/confighubai/flux-bridge/internal/metrics"
+)
+
+// WithMetrics returns a copy of the controller recording metrics of its operations.
+func (c FluxController) WithMetrics(recorder *metrics.Recorder) FluxController {
+	c.metrics = recorder
+	return c
+}
+
+// StorageSize returns the total size of the files in the artifact storage.
+func (c FluxController) StorageSize() (int64, error) {
+	if c.storage == nil {
+		return 0, nil
+	}
+	size := int64(0)
+	err := filepath.WalkDir(c.storage.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// ManagedObjects returns the number of Kustomizations and HelmReleases managed by the
+// bridge, read from the informer caches.
+func (c FluxController) ManagedObjects() (map[string]int, error) {
+	counts := map[string]int{
+		kcv1.KustomizationKind: 0,
+	}
+	watchers := map[string]*objectWatcher{
+		kcv1.KustomizationKind: c.kustWatcher,
+	}
+	if c.helmWatcher != nil {
+		counts[helmv2.HelmReleaseKind] = 0
+		watchers[helmv2.HelmReleaseKind] = c.helmWatcher
+	}
+	for kind, watcher := range watchers {
+		for _, obj := range watcher.list() {
+			if obj.GetLabels()[ManagedByLabelKey] == ControllerName {
+				counts[kind]++
+			}
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MigratedReason is the reason of the events recorded when the objects of a unit are
+// moved from a legacy name to its current name.
+const MigratedReason = "Migrated"
+
+// NameConflictError is returned when the objects with the name of a unit were
+// created for another unit.
+type NameConflictError struct {
+	Name  string
+	Space string
+	Unit  string
+}
+
+func (e *NameConflictError) Error() string {
+	return fmt.Sprintf("name %s is already used by unit %s in space %s", e.Name, e.Unit, e.Space)
+}
+
+// Owns reports whether the External Artifact with the name exists and was created for
+// the unit identified by the annotations. Objects created before annotations were set
+// are assumed to belong to the unit. A NameConflictError is returned when the External
+// Artifact was created for another unit.
+func (c FluxController) Owns(ctx context.Context, name string, annotations map[string]string) (bool, error) {
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&ea), &ea)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	space, unit := ea.Annotations[SpaceAnnotationKey], ea.Annotations[UnitAnnotationKey]
+	if space == "" || unit == "" || annotations[SpaceAnnotationKey] == "" || annotations[UnitAnnotationKey] == "" {
+		return true, nil
+	}
+	if space != annotations[SpaceAnnotationKey] || unit != annotations[UnitAnnotationKey] {
+		return false, &NameConflictError{Name: name, Space: space, Unit: unit}
+	}
+	return true, nil
+}
+
+// ResolveName returns the name the objects of the unit identified by the annotations
+// exist under. This is the legacy name when the unit was created under it and has not
+// been applied since, and the name otherwise.
+func (c FluxController) ResolveName(ctx context.Context, name, legacyName string, annotations map[string]string) (string, error) {
+	if legacyName == "" || legacyName == name {
+		return name, nil
+	}
+	owned, err := c.Owns(ctx, name, annotations)
+	if err != nil || owned {
+		return name, err
+	}
+	owned, err = c.Owns(ctx, legacyName, annotations)
+	var conflictErr *NameConflictError
+	if errors.As(err, &conflictErr) {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if owned {
+		return legacyName, nil
+	}
+	return name, nil
+}
+
+// migrateLegacy removes the objects the unit created under its legacy name, so the
+// objects for the name adopt what they deployed. The Flux objects are suspended before
+// they are deleted, which makes Flux orphan the deployed objects instead of pruning or
+// uninstalling them. The release name of a legacy HelmRelease is returned so the new
+// HelmRelease upgrades the same Helm release.
+func (c FluxController) migrateLegacy(ctx context.Context, name, legacyName string, annotations map[string]string) (string, error) {
+	if legacyName == "" || legacyName == name {
+		return "", nil
+	}
+	owned, err := c.Owns(ctx, legacyName, annotations)
+	var conflictErr *NameConflictError
+	if errors.As(err, &conflictErr) || (err == nil && !owned) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log := c.logger(ctx).WithValues("name", name, "legacyName", legacyName)
+	kust := kcv1.Kustomization{}
+	err = c.orphanAndDelete(ctx, c.kustWatcher, legacyName, &kust)
+	if err != nil {
+		return "", fmt.Errorf("could not migrate Kustomization %s: %w", legacyName, err)
+	}
+	releaseName := ""
+	if c.SupportsHelmRelease() {
+		hr := helmv2.HelmRelease{}
+		err = c.orphanAndDelete(ctx, c.helmWatcher, legacyName, &hr)
+		if err != nil {
+			return "", fmt.Errorf("could not migrate HelmRelease %s: %w", legacyName, err)
+		}
+		if hr.UID != "" {
+			releaseName = hr.GetReleaseName()
+		}
+	}
+	err = c.Delete(ctx, legacyName)
+	if err != nil {
+		return "", fmt.Errorf("could not remove legacy objects %s: %w", legacyName, err)
+	}
+	err = c.repointDependents(ctx, name, legacyName)
+	if err != nil {
+		return "", fmt.Errorf("could not update the dependents of %s: %w", legacyName, err)
+	}
+	log.Info("Migrated unit from legacy name")
+	return releaseName, nil
+}
+
+// orphanAndDelete suspends the Flux object with the name, if it exists, and deletes it
+// once suspended, waiting until it is gone.
+func (c FluxController) orphanAndDelete(ctx context.Context, watcher *objectWatcher, name string, obj client.Object) error {
+	err := c.kubeClient.Get(ctx, client.ObjectKey{Name: name, Namespace: c.namespace}, obj)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	patch := client.RawPatch(types.MergePatchType, []byte(`{"spec":{"suspend":true}}`))
+	err = c.kubeClient.Patch(ctx, obj, patch, client.FieldOwner(ControllerName))
+	if err != nil {
+		return err
+	}
+	c.event(ctx, obj, corev1.EventTypeNormal, MigratedReason, "Suspended and deleted to migrate the unit to a new name, the deployed objects are kept")
+	err = c.kubeClient.Delete(ctx, obj)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return watcher.waitFor(ctx, name, func(obj client.Object) (bool, error) {
+		return obj == nil, nil
+	})
+}
+
+// repointDependents makes the Flux objects depending on the legacy name depend on the
+// name instead, as the objects with the legacy name are gone once the unit is migrated.
+func (c FluxController) repointDependents(ctx context.Context, name, legacyName string) error {
+	kusts := kcv1.KustomizationList{}
+	err := c.kubeClient.List(ctx, &kusts, client.InNamespace(c.namespace))
+	if err != nil {
+		return err
+	}
+	for i := range kusts.Items {
+		kust := &kusts.Items[i]
+		patch := client.MergeFrom(kust.DeepCopy())
+		changed := false
+		for j, dep := range kust.Spec.DependsOn {
+			if c.isLegacyDependency(dep.Name, dep.Namespace, legacyName) {
+				kust.Spec.DependsOn[j].Name = name
+				changed = true
+			}
+		}
+		if changed {
+			err = c.repointDependent(ctx, kust, patch, name, legacyName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !c.SupportsHelmRelease() {
+		return nil
+	}
+	hrs := helmv2.HelmReleaseList{}
+	err = c.kubeClient.List(ctx, &hrs, client.InNamespace(c.namespace))
+	if err != nil {
+		return err
+	}
+	for i := range hrs.Items {
+		hr := &hrs.Items[i]
+		patch := client.MergeFrom(hr.DeepCopy())
+		changed := false
+		for j, dep := range hr.Spec.DependsOn {
+			if c.isLegacyDependency(dep.Name, dep.Namespace, legacyName) {
+				hr.Spec.DependsOn[j].Name = name
+				changed = true
+			}
+		}
+		if changed {
+			err = c.repointDependent(ctx, hr, patch, name, legacyName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isLegacyDependency reports whether the dependency reference points to the legacy name
+// in the bridge namespace.
+func (c FluxController) isLegacyDependency(name, namespace, legacyName string) bool {
+	return name == legacyName && (namespace == "" || namespace == c.namespace)
+}
+
+func (c FluxController) repointDependent(ctx context.Context, obj client.Object, patch client.Patch, name, legacyName string) error {
+	err := c.kubeClient.Patch(ctx, obj, patch, client.FieldOwner(ControllerName))
+	if err != nil {
+		return fmt.Errorf("could not update %T %s: %w", obj, obj.GetName(), err)
+	}
+	c.logger(ctx).Info("Updated dependency of migrated unit", "dependent", obj.GetName(), "name", name, "legacyName", legacyName)
+	c.event(ctx, obj, corev1.EventTypeNormal, MigratedReason, fmt.Sprintf("Dependency %s was migrated to the new name %s", legacyName, name))
+	return nil
+}
+
+// releaseName returns the release name set on the HelmRelease with the name, which is
+// kept when the release was adopted from a legacy HelmRelease.
+func (c FluxController) releaseName(ctx context.Context, name string) (string, error) {
+	hr := helmv2.HelmRelease{}
+	err := c.kubeClient.Get(ctx, client.ObjectKey{Name: name, Namespace: c.namespace}, &hr)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return hr.Spec.ReleaseName, nil
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	DefaultInterval = 1 * time.Minute
+	DefaultTimeout  = 5 * time.Minute
+)
+
+// ApplyOptions configures the Kustomization created when applying a unit.
+type ApplyOptions struct {
+	Interval           time.Duration
+	RetryInterval      time.Duration
+	Timeout            time.Duration
+	Wait               bool
+	Prune              bool
+	Force              bool
+	Suspend            bool
+	TargetNamespace    string
+	ServiceAccountName string
+	// KubeConfigSecret is the name of the Secret in the bridge namespace with the
+	// kubeconfig of the remote cluster to deploy to.
+	KubeConfigSecret string
+	// Rollback restores the previous artifact when the Kustomization fails to
+	// become ready with the new one.
+	Rollback bool
+	// DependsOn are the names of the units in the bridge namespace which must be
+	// ready before this unit is reconciled.
+	DependsOn []string
+	// Layout is the layout of the unit data in the artifact, either LayoutSingle
+	// or LayoutSplit. It defaults to LayoutSingle when empty.
+	Layout string
+	// Reconcile makes a refresh which detects drift reconcile the unit immediately,
+	// correcting the drift instead of waiting for the next interval.
+	Reconcile bool
+	// Space is the ConfigHub space of the unit, used to select the policy rules
+	// which apply to it.
+	Space string
+	// LegacyName is the name the unit's objects were created with under a previous
+	// naming scheme. Objects found under it are migrated to the new name.
+	LegacyName string
+	// Annotations are set on the External Artifact and the Flux object, and except
+	// for the revision as common metadata on the objects they deploy.
+	Annotations map[string]string
+	// Progress is called with status updates while waiting for the Kustomization.
+	Progress ProgressFunc
+	// Yield runs the wait function while the operation waits on other units, so the
+	// caller can free resources held by the operation in the meantime. The wait
+	// function is run directly when it is nil.
+	Yield func(wait func() error) error
+}
+
+// DefaultApplyOptions returns the options used when a unit does not override them.
+func DefaultApplyOptions() ApplyOptions {
+	return ApplyOptions{
+		Interval: DefaultInterval,
+		Timeout:  DefaultTimeout,
+		Wait:     true,
+		Prune:    true,
+	}
+}
+
+// Validate checks that the options can be used to create a Kustomization.
+func (o ApplyOptions) Validate() error {
+	errs := []error{}
+	if o.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("interval must be greater than zero, got %s", o.Interval))
+	}
+	if o.RetryInterval < 0 {
+		errs = append(errs, fmt.Errorf("retry interval can't be negative, got %s", o.RetryInterval))
+	}
+	if o.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be greater than zero, got %s", o.Timeout))
+	}
+	if o.TargetNamespace != "" {
+		if msgs := validation.IsDNS1123Label(o.TargetNamespace); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid target namespace %q: %s", o.TargetNamespace, strings.Join(msgs, ", ")))
+		}
+	}
+	if o.ServiceAccountName != "" {
+		if msgs := validation.IsDNS1123Subdomain(o.ServiceAccountName); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid service account name %q: %s", o.ServiceAccountName, strings.Join(msgs, ", ")))
+		}
+	}
+	if o.KubeConfigSecret != "" {
+		if msgs := validation.IsDNS1123Subdomain(o.KubeConfigSecret); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid kubeconfig secret name %q: %s", o.KubeConfigSecret, strings.Join(msgs, ", ")))
+		}
+	}
+	switch o.Layout {
+	case "", LayoutSingle, LayoutSplit:
+	default:
+		errs = append(errs, fmt.Errorf("invalid layout %q, expected %s or %s", o.Layout, LayoutSingle, LayoutSplit))
+	}
+	for _, dep := range o.DependsOn {
+		if msgs := validation.IsDNS1123Subdomain(dep); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid dependency name %q: %s", dep, strings.Join(msgs, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// yield runs the wait function through the Yield function of the options.
+func (o ApplyOptions) yield(wait func() error) error {
+	if o.Yield == nil {
+		return wait()
+	}
+	return o.Yield(wait)
+}
+
+// kubeConfigRef returns the kubeconfig reference for the remote cluster, or nil when
+// deploying to the local cluster.
+func (o ApplyOptions) kubeConfigRef() *gotkmeta.KubeConfigReference {
+	if o.KubeConfigSecret == "" {
+		return nil
+	}
+	return &gotkmeta.KubeConfigReference{
+		SecretRef: &gotkmeta.SecretKeyReference{
+			Name: o.KubeConfigSecret,
+		},
+	}
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// policyCostLimit bounds the cost of evaluating an expression for an object, the
+	// same limit Kubernetes uses for the CEL expressions of a validating admission policy.
+	policyCostLimit = 1000000
+	// policyInterruptCheckFrequency is how many comprehension iterations run between
+	// checks whether the evaluation was cancelled.
+	policyInterruptCheckFrequency = 100
+)
+
+// Policy restricts the objects units may deploy. Every rule is evaluated for every
+// object of a unit in the spaces the rule applies to.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule is a named set of checks, an object violates the rule when any of the
+// checks fails.
+type PolicyRule struct {
+	Name string `json:"name"`
+	// Spaces are the ConfigHub space slugs the rule applies to, all spaces when empty.
+	Spaces []string `json:"spaces,omitempty"`
+	// AllowedKinds are the kinds objects may have, as Kind or Kind.group.
+	AllowedKinds []string `json:"allowedKinds,omitempty"`
+	// AllowedNamespaces are the namespaces namespaced objects may be deployed to.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// ForbiddenFields are dot separated field paths which may not be set at any depth
+	// of an object. Lists are traversed, and fields set to false or null are allowed.
+	ForbiddenFields []string `json:"forbiddenFields,omitempty"`
+	// Expression is a CEL expression which has to evaluate to true for every object,
+	// with the object available as object and the space slug as space.
+	Expression string `json:"expression,omitempty"`
+	// Message is reported when the expression evaluates to false.
+	Message string `json:"message,omitempty"`
+
+	program cel.Program
+}
+
+// PolicyViolation describes an object violating a policy rule.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when the objects of a unit violate the policy.
+type PolicyError struct {
+	Violations []PolicyViolation `json:"violations"`
+}
+
+func (e *PolicyError) Error() string {
+	msgs := []string{}
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("rule %s: %s: %s", v.Rule, v.Object, v.Message))
+	}
+	return fmt.Sprintf("policy violations: %s", strings.Join(msgs, "; "))
+}
+
+// LoadPolicy reads the policy from a YAML file and compiles its expressions.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	err = yaml.UnmarshalStrict(b, policy)
+	if err != nil {
+		return nil, err
+	}
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("space", cel.StringType),
+	)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	errs := []error{}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("rule %d has no name", i))
+			continue
+		}
+		if slices.Contains(names, rule.Name) {
+			errs = append(errs, fmt.Errorf("rule %s is defined more than once", rule.Name))
+			continue
+		}
+		names = append(names, rule.Name)
+		if rule.Expression == "" {
+			continue
+		}
+		ast, issues := env.Compile(rule.Expression)
+		if issues.Err() != nil {
+			errs = append(errs, fmt.Errorf("rule %s has an invalid expression: %w", rule.Name, issues.Err()))
+			continue
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			errs = append(errs, fmt.Errorf("rule %s expression must evaluate to a bool, got %s", rule.Name, ast.OutputType()))
+			continue
+		}
+		rule.program, err = env.Program(ast, cel.CostLimit(policyCostLimit), cel.InterruptCheckFrequency(policyInterruptCheckFrequency))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s has an invalid expression: %w", rule.Name, err))
+		}
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// WithPolicy returns a copy of the controller rejecting units which violate the policy.
+func (c FluxController) WithPolicy(policy *Policy) FluxController {
+	c.policy = policy
+	return c
+}
+
+// checkPolicy evaluates the policy for the objects in the data, which are placed in
+// the namespaces they will be deployed to by the Kustomization. Whether an object is
+// namespaced is looked up in the cluster the unit is deployed to.
+func (c FluxController) checkPolicy(ctx context.Context, data []byte, opts ApplyOptions) error {
+	if c.policy == nil {
+		return nil
+	}
+	objs, err := ssautils.ReadObjects(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	kubeClient, err := c.clusterClient(ctx, opts.kubeConfigRef())
+	if err != nil {
+		return err
+	}
+	violations := []PolicyViolation{}
+	for _, obj := range objs {
+		namespace := obj.GetNamespace()
+		namespaced, err := kubeClient.IsObjectNamespaced(obj)
+		if err != nil {
+			// Kinds unknown to the cluster are checked with the namespace set in the object.
+			namespaced = namespace != ""
+		}
+		if namespaced && opts.TargetNamespace != "" {
+			namespace = opts.TargetNamespace
+		}
+		if namespaced && namespace == "" {
+			namespace = "default"
+		}
+		if namespaced {
+			obj.SetNamespace(namespace)
+		}
+		violations = append(violations, c.policy.evaluate(ctx, obj, namespaced, opts.Space)...)
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// checkHelmReleasePolicy rejects HelmRelease units in the spaces policy rules apply to.
+// The objects rendered from a chart are only known once helm-controller installs it, so
+// they can't be checked against the policy before they are deployed.
+func (c FluxController) checkHelmReleasePolicy(name string, opts ApplyOptions) error {
+	if c.policy == nil {
+		return nil
+	}
+	id := fmt.Sprintf("%s/%s/%s", helmv2.HelmReleaseKind, c.namespace, name)
+	violations := []PolicyViolation{}
+	for _, rule := range c.policy.Rules {
+		if rule.appliesTo(opts.Space) {
+			violations = append(violations, PolicyViolation{
+				Rule:    rule.Name,
+				Object:  id,
+				Message: "HelmRelease units are not allowed, the objects rendered from the chart can't be checked before they are deployed",
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// appliesTo returns true when the rule is evaluated for the units in the space.
+func (r PolicyRule) appliesTo(space string) bool {
+	return len(r.Spaces) == 0 || slices.Contains(r.Spaces, space)
+}
+
+func (p *Policy) evaluate(ctx context.Context, obj *unstructured.Unstructured, namespaced bool, space string) []PolicyViolation {
+	id := ssautils.FmtUnstructured(obj)
+	violations := []PolicyViolation{}
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(space) {
+			continue
+		}
+		violation := func(format string, a ...any) {
+			violations = append(violations, PolicyViolation{Rule: rule.Name, Object: id, Message: fmt.Sprintf(format, a...)})
+		}
+		if len(rule.AllowedKinds) > 0 {
+			gk := obj.GroupVersionKind().GroupKind()
+			if !slices.Contains(rule.AllowedKinds, gk.Kind) && !slices.Contains(rule.AllowedKinds, gk.String()) {
+				violation("kind %s is not allowed", gk.String())
+			}
+		}
+		if len(rule.AllowedNamespaces) > 0 && namespaced && !slices.Contains(rule.AllowedNamespaces, obj.GetNamespace()) {
+			violation("namespace %s is not allowed", obj.GetNamespace())
+		}
+		for _, field := range rule.ForbiddenFields {
+			for _, path := range findFields(obj.Object, strings.Split(field, "."), "") {
+				violation("field %s is forbidden", path)
+			}
+		}
+		if rule.program != nil {
+			out, _, err := rule.program.ContextEval(ctx, map[string]any{
+				"object": obj.Object,
+				"space":  space,
+			})
+			switch {
+			case err != nil:
+				violation("expression could not be evaluated: %s", err)
+			case out != types.True:
+				msg := rule.Message
+				if msg == "" {
+					msg = fmt.Sprintf("expression %s is not true", rule.Expression)
+				}
+				violation("%s", msg)
+			}
+		}
+	}
+	return violations
+}
+
+// findFields returns the locations where the field path is set in the value, starting
+// at any depth.
+func findFields(value any, path []string, location string) []string {
+	found := []string{}
+	switch v := value.(type) {
+	case map[string]any:
+		found = append(found, matchFields(v, path, location)...)
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			found = append(found, findFields(v[k], path, joinLocation(location, k))...)
+		}
+	case []any:
+		for i, item := range v {
+			found = append(found, findFields(item, path, fmt.Sprintf("%s[%d]", location, i))...)
+		}
+	}
+	return found
+}
+
+// matchFields returns the locations where the field path is set starting at the value.
+func matchFields(value any, path []string, location string) []string {
+	if len(path) == 0 {
+		switch value {
+		case nil, false:
+			return nil
+		}
+		return []string{location}
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		field, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		return matchFields(field, path[1:], joinLocation(location, path[0]))
+	case []any:
+		found := []string{}
+		for i, item := range v {
+			found = append(found, matchFields(item, path, fmt.Sprintf("%s[%d]", location, i))...)
+		}
+		return found
+	}
+	return nil
+}
+
+func joinLocation(location, field string) string {
+	if location == "" {
+		return field
+	}
+	return location + "." + field
+}
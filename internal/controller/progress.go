@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	kstatus "github.com/fluxcd/cli-utils/pkg/kstatus/status"
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// progressInterval is the interval at which the status of the applied objects is
+// checked while waiting for the Kustomization to become ready.
+const progressInterval = 10 * time.Second
+
+// ProgressFunc is called with a human readable message when the progress of an
+// apply changes.
+type ProgressFunc func(message string)
+
+// progressReporter reports changes to the Kustomization conditions and the status of
+// the applied objects while waiting, skipping messages which have not changed.
+type progressReporter struct {
+	send       ProgressFunc
+	kubeClient client.Client
+	objects    []*unstructured.Unstructured
+
+	mu        sync.Mutex
+	last      string
+	objStatus map[string]kstatus.Status
+}
+
+// newProgressReporter creates a reporter checking the status of the objects with the
+// client for the cluster they are applied to.
+func newProgressReporter(send ProgressFunc, kubeClient client.Client, objects []*unstructured.Unstructured) *progressReporter {
+	return &progressReporter{
+		send:       send,
+		kubeClient: kubeClient,
+		objects:    objects,
+		objStatus:  map[string]kstatus.Status{},
+	}
+}
+
+func (p *progressReporter) report(msg string) {
+	if p == nil || p.send == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msg == p.last {
+		return
+	}
+	p.last = msg
+	p.send(msg)
+}
+
+// conditionsChanged reports the Reconciling, Ready and Healthy conditions of the Flux object.
+func (p *progressReporter) conditionsChanged(kind, name string, conditions []metav1.Condition) {
+	if p == nil || p.send == nil {
+		return
+	}
+	conds := []string{}
+	for _, condType := range []string{gotkmeta.ReconcilingCondition, gotkmeta.ReadyCondition, gotkmeta.HealthyCondition} {
+		cond := apimeta.FindStatusCondition(conditions, condType)
+		if cond == nil {
+			continue
+		}
+		msg := fmt.Sprintf("%s=%s (%s)", cond.Type, cond.Status, cond.Reason)
+		if cond.Message != "" {
+			msg = fmt.Sprintf("%s: %s", msg, cond.Message)
+		}
+		conds = append(conds, msg)
+	}
+	if len(conds) == 0 {
+		return
+	}
+	p.report(fmt.Sprintf("%s %s: %s", kind, name, strings.Join(conds, "; ")))
+}
+
+// run periodically reports objects whose status changed until the context is cancelled.
+func (p *progressReporter) run(ctx context.Context) {
+	if p == nil || p.send == nil || len(p.objects) == 0 {
+		return
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkObjects(ctx)
+		}
+	}
+}
+
+// checkObjects reports the objects which are not ready yet. Objects are not fetched
+// again once they were ready, so the API server is only polled for the objects still
+// being waited on, and Flux reports objects which become unhealthy again.
+func (p *progressReporter) checkObjects(ctx context.Context) {
+	pending := []string{}
+	for _, obj := range p.objects {
+		if p.objStatus[ssautils.FmtUnstructured(obj)] == kstatus.CurrentStatus {
+			continue
+		}
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(obj.GroupVersionKind())
+		err := p.kubeClient.Get(ctx, client.ObjectKeyFromObject(obj), u)
+		if apierrors.IsNotFound(err) {
+			pending = append(pending, fmt.Sprintf("%s (NotFound)", ssautils.FmtUnstructured(obj)))
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		result, err := kstatus.Compute(u)
+		if err != nil {
+			continue
+		}
+		id := ssautils.FmtUnstructured(obj)
+		if prev, ok := p.objStatus[id]; ok && prev != result.Status && result.Status == kstatus.CurrentStatus {
+			p.report(fmt.Sprintf("%s is %s", id, result.Status))
+		}
+		p.objStatus[id] = result.Status
+		if result.Status != kstatus.CurrentStatus {
+			pending = append(pending, fmt.Sprintf("%s (%s: %s)", id, result.Status, result.Message))
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	p.report(fmt.Sprintf("Waiting for %d object(s) to become ready: %s", len(pending), strings.Join(pending, "; ")))
+}
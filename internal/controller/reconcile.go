@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kcv1 "github.com/fluxcd/kustomize-controller/api/v1"
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileRequestFieldOwner owns the reconcile request annotation. It differs from the
+// field manager applying the Flux objects, so the annotation is kept when they are applied.
+const reconcileRequestFieldOwner = ControllerName + "-reconcile"
+
+// requestReconcile sets the reconcile request annotation on the Flux object, making its
+// controller reconcile it immediately instead of at the next interval. The External
+// Artifact of the unit is annotated with the same request, so both record when it was
+// made. The object is updated with the result, so the request can be compared with the
+// last handled one.
+func (c FluxController) requestReconcile(ctx context.Context, obj client.Object) error {
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	patch := client.RawPatch(types.MergePatchType, fmt.Appendf(nil, `{"metadata":{"annotations":{%q:%q}}}`, gotkmeta.ReconcileRequestAnnotation, requestedAt))
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      obj.GetName(),
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Patch(ctx, &ea, patch, client.FieldOwner(reconcileRequestFieldOwner))
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	c.logger(ctx).V(1).Info("Requested reconciliation", "kind", obj.GetObjectKind().GroupVersionKind().Kind, "name", obj.GetName(), "requestedAt", requestedAt)
+	return c.kubeClient.Patch(ctx, obj, patch, client.FieldOwner(reconcileRequestFieldOwner))
+}
+
+// reconcileRequestHandled returns true when the controller has handled the reconcile
+// request of the object, or no reconcile was requested.
+func reconcileRequestHandled(requested client.Object, status gotkmeta.ReconcileRequestStatus) bool {
+	requestedAt, ok := requested.GetAnnotations()[gotkmeta.ReconcileRequestAnnotation]
+	return !ok || status.LastHandledReconcileAt == requestedAt
+}
+
+// Reconcile requests a reconciliation of the Kustomization, correcting drift in the
+// cluster, and waits for it to complete.
+func (c FluxController) Reconcile(ctx context.Context, name string, progress ProgressFunc) error {
+	kust := kcv1.Kustomization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&kust), &kust)
+	if err != nil {
+		return err
+	}
+	if kust.Spec.Suspend {
+		return fmt.Errorf("Kustomization %s is suspended", name)
+	}
+	err = c.requestReconcile(ctx, &kust)
+	if err != nil {
+		return err
+	}
+	return c.waitForCurrentStatus(ctx, kust, kust.Status.LastAttemptedRevision, newProgressReporter(progress, nil, nil))
+}
+
+// ReconcileHelmRelease requests a reconciliation of the HelmRelease, correcting drift
+// in the cluster, and waits for it to complete.
+func (c FluxController) ReconcileHelmRelease(ctx context.Context, name string, progress ProgressFunc) error {
+	hr := helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&hr), &hr)
+	if err != nil {
+		return err
+	}
+	if hr.Spec.Suspend {
+		return fmt.Errorf("HelmRelease %s is suspended", name)
+	}
+	err = c.requestReconcile(ctx, &hr)
+	if err != nil {
+		return err
+	}
+	return c.waitForHelmReleaseStatus(ctx, hr, hr.Status.LastAttemptedRevision, newProgressReporter(progress, nil, nil))
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ArtifactMissingReason signals that the artifact is missing from storage and
+	// has to be restored.
+	ArtifactMissingReason = "ArtifactMissing"
+
+	backupNamePrefix    = "flux-bridge-artifact-"
+	backupDataKey       = "artifact.tar.gz"
+	backupRevisionKey   = "revision"
+	backupSecretDataCap = 1024 * 1024
+)
+
+// backupArtifact stores a copy of the artifact in a Secret, so that it can be
+// restored when the storage is lost.
+func (c FluxController) backupArtifact(ctx context.Context, ea scv1.ExternalArtifact, artifact gotkmeta.Artifact) error {
+	data, err := os.ReadFile(c.storage.LocalPath(artifact))
+	if err != nil {
+		return err
+	}
+	if len(data) > backupSecretDataCap {
+		return fmt.Errorf("artifact size %d exceeds the maximum backup size of %d bytes", len(data), backupSecretDataCap)
+	}
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupNamePrefix + ea.Name,
+			Namespace: c.namespace,
+			Labels: map[string]string{
+				ManagedByLabelKey: ControllerName,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			backupDataKey:     data,
+			backupRevisionKey: []byte(artifact.Revision),
+		},
+	}
+	return c.kubeClient.Patch(ctx, &secret, client.Apply, &client.PatchOptions{
+		FieldManager: ControllerName,
+		Force:        ptr.To(true),
+	})
+}
+
+// deleteBackup removes the artifact backup of the External Artifact.
+func (c FluxController) deleteBackup(ctx context.Context, name string) error {
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupNamePrefix + name,
+			Namespace: c.namespace,
+		},
+	}
+	return client.IgnoreNotFound(c.kubeClient.Delete(ctx, &secret))
+}
+
+// Rehydrate restores the artifacts of all External Artifacts which are missing from
+// storage, for example after the bridge restarted without persistent storage. The
+// External Artifact is marked as not ready until its artifact is restored from the
+// backup, or the unit is applied again when no backup exists.
+func (c FluxController) Rehydrate(ctx context.Context) error {
+	eaList := scv1.ExternalArtifactList{}
+	err := c.kubeClient.List(ctx, &eaList, client.InNamespace(c.namespace), client.MatchingLabels{ManagedByLabelKey: ControllerName})
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, ea := range eaList.Items {
+		if ea.Status.Artifact == nil || c.storage.ArtifactExist(*ea.Status.Artifact) {
+			continue
+		}
+		artifact := *ea.Status.Artifact
+		err := c.patchStatus(ctx, &ea, artifact, metav1.ConditionFalse, ArtifactMissingReason, "Artifact is missing from storage and is being restored")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = c.restoreArtifact(ctx, ea.Name, artifact)
+		if err != nil {
+			c.log.Error(err, "Could not restore artifact", "name", ea.Name, "revision", artifact.Revision)
+			// Surface the failure on the External Artifact, it is resolved by applying the unit again.
+			msg := fmt.Sprintf("Artifact could not be restored, apply the unit again to recreate it: %s", err)
+			err = c.patchStatus(ctx, &ea, artifact, metav1.ConditionFalse, ArtifactMissingReason, msg)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		err = c.patchStatus(ctx, &ea, artifact, metav1.ConditionTrue, gotkmeta.SucceededReason, "Artifact is ready")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.log.Info("Restored artifact", "name", ea.Name, "revision", artifact.Revision)
+	}
+	return errors.Join(errs...)
+}
+
+// restoreArtifact writes the artifact back to storage from its backup.
+func (c FluxController) restoreArtifact(ctx context.Context, name string, artifact gotkmeta.Artifact) error {
+	secret := corev1.Secret{}
+	err := c.kubeClient.Get(ctx, client.ObjectKey{Name: backupNamePrefix + name, Namespace: c.namespace}, &secret)
+	if apierrors.IsNotFound(err) {
+		return errors.New("no backup exists")
+	}
+	if err != nil {
+		return err
+	}
+	revision := string(secret.Data[backupRevisionKey])
+	if revision != artifact.Revision {
+		return fmt.Errorf("backup revision %s does not match artifact revision %s", revision, artifact.Revision)
+	}
+	err = c.storage.MkdirAll(artifact)
+	if err != nil {
+		return err
+	}
+	restored := artifact.DeepCopy()
+	err = c.storage.Copy(restored, bytes.NewReader(secret.Data[backupDataKey]))
+	if err != nil {
+		return err
+	}
+	err = c.storage.VerifyArtifact(artifact)
+	if err != nil {
+		_ = c.storage.Remove(artifact)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	gotkmeta "github.com/fluxcd/pkg/apis/meta"
+	scv1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RollbackError is returned by Apply when the Kustomization failed to become ready
+// with the new revision and the previous revision was successfully restored.
+type RollbackError struct {
+	Err      error
+	Revision string
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%s, rolled back to revision %s", e.Err, e.Revision)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// servedArtifact is an artifact served by an External Artifact, with the annotations of
+// the External Artifact identifying the unit revision it was stored for.
+type servedArtifact struct {
+	artifact    gotkmeta.Artifact
+	annotations map[string]string
+}
+
+// previousArtifact returns the artifact currently referenced by the External Artifact,
+// or nil if there is no other revision stored which can be rolled back to.
+func (c FluxController) previousArtifact(ctx context.Context, name, revision string) (*servedArtifact, error) {
+	ea := scv1.ExternalArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.namespace,
+		},
+	}
+	err := c.kubeClient.Get(ctx, client.ObjectKeyFromObject(&ea), &ea)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ea.Status.Artifact == nil || ea.Status.Artifact.Revision == revision || !c.storage.ArtifactExist(*ea.Status.Artifact) {
+		return nil, nil
+	}
+	return &servedArtifact{
+		artifact:    *ea.Status.Artifact.DeepCopy(),
+		annotations: originAnnotations(ea.Annotations),
+	}, nil
+}
+
+// rollback points the External Artifact back at the previous artifact after applying a
+// new revision failed, and waits for the Flux object to become ready with it again. The
+// wait function restores the annotations of the previous revision on the Flux object.
+// Events are recorded on the External Artifact and the Flux object.
+func (c FluxController) rollback(ctx context.Context, obj client.Object, ea scv1.ExternalArtifact, served servedArtifact, applyErr error, progress *progressReporter, wait func(previous servedArtifact) error) error {
+	previous := served.artifact
+	progress.report(fmt.Sprintf("Apply failed, rolling back to revision %s: %s", previous.Revision, applyErr))
+	c.logger(ctx).Error(applyErr, "Apply failed, rolling back", "name", ea.Name, "previousRevision", previous.Revision)
+	err := c.restoreArtifactAnnotations(ctx, &ea, served.annotations)
+	if err == nil {
+		err = c.patchArtifactStatus(ctx, &ea, previous)
+	}
+	if err == nil {
+		err = wait(served)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w, rollback to revision %s failed: %w", applyErr, previous.Revision, err)
+		for _, o := range []client.Object{&ea, obj} {
+			c.event(ctx, o, corev1.EventTypeWarning, RollbackFailedReason, err.Error())
+		}
+		return err
+	}
+	msg := fmt.Sprintf("Rolled back to revision %s: %s", previous.Revision, applyErr)
+	for _, o := range []client.Object{&ea, obj} {
+		c.event(ctx, o, corev1.EventTypeWarning, RolledBackReason, msg)
+	}
+	return &RollbackError{Err: applyErr, Revision: previous.Revision}
+}
+
+// restoreArtifactAnnotations sets the annotations of the revision rolled back to on the
+// External Artifact.
+func (c FluxController) restoreArtifactAnnotations(ctx context.Context, ea *scv1.ExternalArtifact, annotations map[string]string) error {
+	restored := scv1.ExternalArtifact{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: scv1.GroupVersion.String(),
+			Kind:       scv1.ExternalArtifactKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ea.Name,
+			Namespace: ea.Namespace,
+			Labels: map[string]string{
+				ManagedByLabelKey: ControllerName,
+			},
+			Annotations: annotations,
+		},
+	}
+	err := c.kubeClient.Patch(ctx, &restored, client.Apply, &client.PatchOptions{
+		FieldManager: ControllerName,
+		Force:        ptr.To(true),
+	})
+	if err != nil {
+		return err
+	}
+	ea.Annotations = restored.Annotations
+	return nil
+}
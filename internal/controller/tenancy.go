@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SpaceLabelKey is set on the tenant namespaces and service accounts to the
+// ConfigHub space they belong to.
+const SpaceLabelKey = "flux-bridge.confighub.com/space"
+
+// Tenancy maps each ConfigHub space to a tenant namespace and a service account in the
+// bridge namespace, which units of the space are deployed to and impersonate.
+type Tenancy struct {
+	namespace      *template.Template
+	serviceAccount *template.Template
+	clusterRole    string
+}
+
+// NewTenancy parses the templates of the tenant namespace and service account names,
+// which are executed with the space slug as .Space.
+func NewTenancy(namespaceTemplate, serviceAccountTemplate, clusterRole string) (*Tenancy, error) {
+	namespace, err := template.New("namespace").Option("missingkey=error").Parse(namespaceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant namespace template: %w", err)
+	}
+	serviceAccount, err := template.New("service account").Option("missingkey=error").Parse(serviceAccountTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant service account template: %w", err)
+	}
+	if clusterRole == "" {
+		return nil, errors.New("tenant cluster role can't be empty")
+	}
+	// Spaces sharing a namespace or service account would not be isolated.
+	for _, t := range []*template.Template{namespace, serviceAccount} {
+		a, errA := executeName(t, "a")
+		b, errB := executeName(t, "b")
+		if err := errors.Join(errA, errB); err != nil {
+			return nil, fmt.Errorf("invalid tenant %s template: %w", t.Name(), err)
+		}
+		if a == b {
+			return nil, fmt.Errorf("tenant %s template must contain {{ .Space }}, so every space gets its own %s", t.Name(), t.Name())
+		}
+	}
+	return &Tenancy{
+		namespace:      namespace,
+		serviceAccount: serviceAccount,
+		clusterRole:    clusterRole,
+	}, nil
+}
+
+// CheckTenancy checks that the bridge is allowed to bind the tenant cluster role, so a
+// cluster role the bridge was not granted fails at startup instead of on every apply.
+func (c FluxController) CheckTenancy(ctx context.Context) error {
+	if c.tenancy == nil {
+		return nil
+	}
+	review := authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     "bind",
+				Group:    rbacv1.GroupName,
+				Resource: "clusterroles",
+				Name:     c.tenancy.clusterRole,
+			},
+		},
+	}
+	err := c.kubeClient.Create(ctx, &review)
+	if err != nil {
+		return fmt.Errorf("could not check access to tenant cluster role %s: %w", c.tenancy.clusterRole, err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("the bridge is not allowed to bind tenant cluster role %s, grant it the bind permission on the cluster role", c.tenancy.clusterRole)
+	}
+	return nil
+}
+
+// WithTenancy returns a copy of the controller deploying units with the namespace and
+// service account of their space.
+func (c FluxController) WithTenancy(tenancy *Tenancy) FluxController {
+	c.tenancy = tenancy
+	return c
+}
+
+// tenantOptions sets the target namespace and service account of the space of the unit.
+// Options selecting another namespace or service account are rejected, as they would
+// escape the isolation of the tenant.
+func (c FluxController) tenantOptions(opts ApplyOptions) (ApplyOptions, error) {
+	if c.tenancy == nil {
+		return opts, nil
+	}
+	if opts.Space == "" {
+		return ApplyOptions{}, errors.New("space is required for tenant isolation")
+	}
+	namespace, err := executeName(c.tenancy.namespace, opts.Space)
+	if err != nil {
+		return ApplyOptions{}, err
+	}
+	if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+		return ApplyOptions{}, fmt.Errorf("invalid tenant namespace %q: %s", namespace, strings.Join(msgs, ", "))
+	}
+	if namespace == c.namespace || namespace == "default" || namespace == "flux-system" || strings.HasPrefix(namespace, "kube-") {
+		return ApplyOptions{}, fmt.Errorf("tenant namespace %s of space %s is reserved", namespace, opts.Space)
+	}
+	serviceAccount, err := executeName(c.tenancy.serviceAccount, opts.Space)
+	if err != nil {
+		return ApplyOptions{}, err
+	}
+	if msgs := validation.IsDNS1123Subdomain(serviceAccount); len(msgs) > 0 {
+		return ApplyOptions{}, fmt.Errorf("invalid tenant service account name %q: %s", serviceAccount, strings.Join(msgs, ", "))
+	}
+	if opts.TargetNamespace != "" && opts.TargetNamespace != namespace {
+		return ApplyOptions{}, fmt.Errorf("target namespace %s is not allowed, units of space %s are deployed to %s", opts.TargetNamespace, opts.Space, namespace)
+	}
+	if opts.ServiceAccountName != "" && opts.ServiceAccountName != serviceAccount {
+		return ApplyOptions{}, fmt.Errorf("service account %s is not allowed, units of space %s are deployed with %s", opts.ServiceAccountName, opts.Space, serviceAccount)
+	}
+	opts.TargetNamespace = namespace
+	opts.ServiceAccountName = serviceAccount
+	return opts, nil
+}
+
+func executeName(tmpl *template.Template, space string) (string, error) {
+	buf := bytes.Buffer{}
+	err := tmpl.Execute(&buf, struct{ Space string }{Space: space})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ensureTenant creates the tenant namespace and service account of the unit in the
+// cluster it is deployed to, and binds the tenant cluster role to the service account
+// in the tenant namespace.
+func (c FluxController) ensureTenant(ctx context.Context, opts ApplyOptions) error {
+	if c.tenancy == nil {
+		return nil
+	}
+	kubeClient, err := c.clusterClient(ctx, opts.kubeConfigRef())
+	if err != nil {
+		return err
+	}
+	// The cluster role is only bound in namespaces created for the space.
+	ns := corev1.Namespace{}
+	err = kubeClient.Get(ctx, client.ObjectKey{Name: opts.TargetNamespace}, &ns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not create tenant %s: %w", opts.Space, err)
+	}
+	if err == nil && ns.Labels[SpaceLabelKey] != opts.Space {
+		return fmt.Errorf("could not create tenant %s: namespace %s already exists and was not created for the space", opts.Space, opts.TargetNamespace)
+	}
+	labels := map[string]string{
+		ManagedByLabelKey: ControllerName,
+		SpaceLabelKey:     opts.Space,
+	}
+	// Flux impersonates the service account in the namespace of the Flux objects, which
+	// may not exist in a remote cluster.
+	if opts.KubeConfigSecret != "" {
+		err = kubeClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.namespace,
+				Labels: map[string]string{
+					ManagedByLabelKey: ControllerName,
+				},
+			},
+		})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("could not create namespace %s of the tenant service accounts in the cluster of kubeconfig Secret %s: %w", c.namespace, opts.KubeConfigSecret, err)
+		}
+	}
+	objs := []client.Object{
+		&corev1.Namespace{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Namespace",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   opts.TargetNamespace,
+				Labels: labels,
+			},
+		},
+		&corev1.ServiceAccount{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "ServiceAccount",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      opts.ServiceAccountName,
+				Namespace: c.namespace,
+				Labels:    labels,
+			},
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: rbacv1.SchemeGroupVersion.String(),
+				Kind:       "RoleBinding",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ControllerName + "-" + opts.ServiceAccountName,
+				Namespace: opts.TargetNamespace,
+				Labels:    labels,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     c.tenancy.clusterRole,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      opts.ServiceAccountName,
+					Namespace: c.namespace,
+				},
+			},
+		},
+	}
+	for _, obj := range objs {
+		err := kubeClient.Patch(ctx, obj, client.Apply, &client.PatchOptions{
+			FieldManager: ControllerName,
+			Force:        ptr.To(true),
+		})
+		if err != nil {
+			return fmt.Errorf("could not create tenant %s: %w", opts.Space, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ssautils "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ObjectError lists the problems found with a single document of the unit data.
+type ObjectError struct {
+	// Document is the index of the document in the unit data, starting at zero.
+	Document int `json:"document"`
+	// Object identifies the object as Kind/namespace/name, when the document is a
+	// Kubernetes object.
+	Object string   `json:"object,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+func (e ObjectError) String() string {
+	id := e.Object
+	if id == "" {
+		id = fmt.Sprintf("document %d", e.Document)
+	}
+	return fmt.Sprintf("%s: %s", id, strings.Join(e.Errors, ", "))
+}
+
+// ValidationError is returned when the unit data is rejected before it is applied.
+type ValidationError struct {
+	Objects []ObjectError `json:"objects"`
+}
+
+func (e *ValidationError) Error() string {
+	objs := []string{}
+	for _, obj := range e.Objects {
+		objs = append(objs, obj.String())
+	}
+	return fmt.Sprintf("invalid data: %s", strings.Join(objs, "; "))
+}
+
+// SchemaValidator validates objects against the JSON schemas of their kind.
+type SchemaValidator struct {
+	schemas map[schema.GroupVersionKind]*jsonschema.Schema
+}
+
+// NewSchemaValidator loads the schemas in the directory. JSON files are standalone
+// JSON schemas identified by their x-kubernetes-group-version-kind extension, as
+// published for the built-in Kubernetes kinds. YAML files may contain
+// CustomResourceDefinitions, whose OpenAPI schemas are used for the custom resources.
+func NewSchemaValidator(dir string) (*SchemaValidator, error) {
+	v := &SchemaValidator{
+		schemas: map[schema.GroupVersionKind]*jsonschema.Schema{},
+	}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".json":
+			err = v.loadJSONSchema(path)
+		case ".yaml", ".yml":
+			err = v.loadCRDs(path)
+		}
+		if err != nil {
+			return fmt.Errorf("could not load schema %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *SchemaValidator) loadJSONSchema(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ext := struct {
+		GVKs []schema.GroupVersionKind `json:"x-kubernetes-group-version-kind"`
+	}{}
+	err = json.Unmarshal(b, &ext)
+	if err != nil {
+		return err
+	}
+	if len(ext.GVKs) == 0 {
+		return errors.New("schema has no x-kubernetes-group-version-kind")
+	}
+	s, err := compileSchema(path, b)
+	if err != nil {
+		return err
+	}
+	for _, gvk := range ext.GVKs {
+		v.schemas[gvk] = s
+	}
+	return nil
+}
+
+func (v *SchemaValidator) loadCRDs(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096)
+	for {
+		crd := apiextensionsv1.CustomResourceDefinition{}
+		err := decoder.Decode(&crd)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if crd.Kind != "CustomResourceDefinition" {
+			continue
+		}
+		for _, version := range crd.Spec.Versions {
+			if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			b, err := json.Marshal(version.Schema.OpenAPIV3Schema)
+			if err != nil {
+				return err
+			}
+			s, err := compileSchema(fmt.Sprintf("%s/%s/%s", path, crd.Name, version.Name), b)
+			if err != nil {
+				return err
+			}
+			gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: version.Name, Kind: crd.Spec.Names.Kind}
+			v.schemas[gvk] = s
+		}
+	}
+}
+
+func compileSchema(url string, b []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	// Kubernetes OpenAPI schemas follow draft 4, for example with boolean exclusive bounds.
+	compiler.Draft = jsonschema.Draft4
+	err := compiler.AddResource(url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Compile(url)
+}
+
+// validateObject returns the schema violations of the object, objects without a
+// schema are not validated.
+func (v *SchemaValidator) validateObject(obj *unstructured.Unstructured) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, ok := v.schemas[obj.GroupVersionKind()]
+	if !ok {
+		return nil, nil
+	}
+	// The schema validates JSON values, so round trip the object to get JSON numbers.
+	b, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Validate(doc)
+	verr := &jsonschema.ValidationError{}
+	if errors.As(err, &verr) {
+		return validationMessages(verr), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// validationMessages flattens the validation error into a message per invalid field.
+func validationMessages(verr *jsonschema.ValidationError) []string {
+	if len(verr.Causes) == 0 {
+		location := verr.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{fmt.Sprintf("%s: %s", location, verr.Message)}
+	}
+	msgs := []string{}
+	for _, cause := range verr.Causes {
+		for _, msg := range validationMessages(cause) {
+			if !slices.Contains(msgs, msg) {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	return msgs
+}
+
+// validateData checks that every document in the data is a Kubernetes object, that
+// no object is defined more than once and that the objects match their schemas.
+func validateData(data []byte, schemas *SchemaValidator) error {
+	objErrs := []ObjectError{}
+	ids := map[string]int{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for i := 0; ; i++ {
+		var doc any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			objErrs = append(objErrs, ObjectError{Document: i, Errors: []string{fmt.Sprintf("invalid YAML: %s", err)}})
+			// The decoder can't continue after a syntax error.
+			break
+		}
+		if doc == nil {
+			continue
+		}
+		raw, ok := doc.(map[string]any)
+		if !ok {
+			objErrs = append(objErrs, ObjectError{Document: i, Errors: []string{"not a Kubernetes object"}})
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: raw}
+		missing := []string{}
+		for _, field := range []string{"apiVersion", "kind", "metadata.name"} {
+			value, _, _ := unstructured.NestedString(raw, strings.Split(field, ".")...)
+			if value == "" {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			objErrs = append(objErrs, ObjectError{Document: i, Errors: []string{fmt.Sprintf("not a Kubernetes object, missing %s", strings.Join(missing, ", "))}})
+			continue
+		}
+
+		objErr := ObjectError{
+			Document: i,
+			Object:   ssautils.FmtUnstructured(obj),
+		}
+		gvk := obj.GroupVersionKind()
+		id := strings.Join([]string{gvk.Group, gvk.Kind, obj.GetNamespace(), obj.GetName()}, "/")
+		if first, ok := ids[id]; ok {
+			objErr.Errors = append(objErr.Errors, fmt.Sprintf("duplicate object, first defined in document %d", first))
+		} else {
+			ids[id] = i
+		}
+		msgs, err := schemas.validateObject(obj)
+		if err != nil {
+			return err
+		}
+		objErr.Errors = append(objErr.Errors, msgs...)
+		if len(objErr.Errors) > 0 {
+			objErrs = append(objErrs, objErr)
+		}
+	}
+	if len(objErrs) > 0 {
+		return &ValidationError{Objects: objErrs}
+	}
+	return nil
+}
+
+// WithSchemaValidator returns a copy of the controller validating the objects of
+// applied units against the schemas.
+func (c FluxController) WithSchemaValidator(schemas *SchemaValidator) FluxController {
+	c.schemas = schemas
+	return c
+}
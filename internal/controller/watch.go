@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// objectWatcher caches all objects of a single kind in the namespace using a
+// shared informer, and wakes up waiters when an object with their name changes.
+type objectWatcher struct {
+	informer  toolscache.SharedIndexInformer
+	namespace string
+
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+// cacheSyncTimeout is how long to wait for the cache of a watcher to sync.
+const cacheSyncTimeout = 2 * time.Minute
+
+// newObjectWatcher starts an informer for the object kind and waits for its cache to sync.
+// The objects are listed once before, so a missing API or permission fails immediately
+// instead of blocking until the sync times out. The informer is stopped when the context
+// is cancelled.
+func newObjectWatcher(ctx context.Context, kubeClient client.WithWatch, namespace string, obj client.Object, list client.ObjectList) (*objectWatcher, error) {
+	err := kubeClient.List(ctx, list.DeepCopyObject().(client.ObjectList), client.InNamespace(namespace), client.Limit(1))
+	if err != nil {
+		return nil, fmt.Errorf("could not list %T in namespace %s: %w", obj, namespace, err)
+	}
+
+	lw := &toolscache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			l := list.DeepCopyObject().(client.ObjectList)
+			err := kubeClient.List(ctx, l, client.InNamespace(namespace), &client.ListOptions{Raw: &opts})
+			return l, err
+		},
+		WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			l := list.DeepCopyObject().(client.ObjectList)
+			return kubeClient.Watch(ctx, l, client.InNamespace(namespace), &client.ListOptions{Raw: &opts})
+		},
+	}
+	w := &objectWatcher{
+		informer:  toolscache.NewSharedIndexInformer(lw, obj, 0, toolscache.Indexers{}),
+		namespace: namespace,
+		waiters:   map[string]map[chan struct{}]struct{}{},
+	}
+	_, err = w.informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    w.notify,
+		UpdateFunc: func(_, newObj any) { w.notify(newObj) },
+		DeleteFunc: w.notify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go w.informer.RunWithContext(ctx)
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	if !toolscache.WaitForCacheSync(syncCtx.Done(), w.informer.HasSynced) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("timed out after %s waiting for %T cache to sync in namespace %s", cacheSyncTimeout, obj, namespace)
+	}
+	return w, nil
+}
+
+// get returns a copy of the cached object, or nil if it does not exist.
+func (w *objectWatcher) get(name string) (client.Object, error) {
+	item, exists, err := w.informer.GetStore().GetByKey(w.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	obj, ok := item.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T in cache", item)
+	}
+	return obj.DeepCopyObject().(client.Object), nil
+}
+
+// list returns the cached objects, which must not be modified.
+func (w *objectWatcher) list() []client.Object {
+	objs := []client.Object{}
+	for _, item := range w.informer.GetStore().List() {
+		if obj, ok := item.(client.Object); ok {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// waitFor blocks until the condition is met for the object with the given name. The
+// condition is evaluated against the cached object, which is nil when it does not
+// exist, initially and every time the object changes.
+func (w *objectWatcher) waitFor(ctx context.Context, name string, condition func(obj client.Object) (bool, error)) error {
+	if w == nil {
+		return errors.New("watcher is not initialized")
+	}
+	ch := w.subscribe(name)
+	defer w.unsubscribe(name, ch)
+	for {
+		obj, err := w.get(name)
+		if err != nil {
+			return err
+		}
+		done, err := condition(obj)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+func (w *objectWatcher) subscribe(name string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if w.waiters[name] == nil {
+		w.waiters[name] = map[chan struct{}]struct{}{}
+	}
+	w.waiters[name][ch] = struct{}{}
+	return ch
+}
+
+func (w *objectWatcher) unsubscribe(name string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.waiters[name], ch)
+	if len(w.waiters[name]) == 0 {
+		delete(w.waiters, name)
+	}
+}
+
+func (w *objectWatcher) notify(item any) {
+	if tombstone, ok := item.(toolscache.DeletedFinalStateUnknown); ok {
+		item = tombstone.Obj
+	}
+	obj, ok := item.(client.Object)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiters[obj.GetName()] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
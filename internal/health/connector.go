@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/confighubai/flux-bridge/internal/metrics"
+)
+
+const (
+	// DefaultProbeInterval is the interval at which the leader checks that ConfigHub
+	// can be reached.
+	DefaultProbeInterval = 30 * time.Second
+
+	// connectorProbeTimeout is the time given to ConfigHub to answer a probe.
+	connectorProbeTimeout = 5 * time.Second
+)
+
+const (
+	connectorStopped int32 = iota
+	connectorStandby
+	connectorRunning
+)
+
+// ConnectorStatus tracks the ConfigHub connector. The connector does not report the
+// state of its connection, whether ConfigHub can be reached is probed separately by the
+// replica running it.
+type ConnectorStatus struct {
+	apiURL string
+	client *http.Client
+	state  atomic.Int32
+	// lastProbe holds the error of the last probe, nil when ConfigHub was reached.
+	lastProbe atomic.Pointer[error]
+}
+
+// NewConnectorStatus creates the status of a connector connecting to the ConfigHub URL.
+func NewConnectorStatus(configHubURL string) *ConnectorStatus {
+	return &ConnectorStatus{
+		apiURL: configHubURL + "/api/info",
+		client: &http.Client{Timeout: connectorProbeTimeout},
+	}
+}
+
+// Standby marks the connector as waiting to run, for another replica to stop running
+// it or for the replica to be prepared.
+func (s *ConnectorStatus) Standby() {
+	s.state.Store(connectorStandby)
+}
+
+// Run runs the connector start function, marking the connector as running until it
+// returns.
+func (s *ConnectorStatus) Run(start func() error) error {
+	s.state.Store(connectorRunning)
+	defer s.state.Store(connectorStopped)
+	return start()
+}
+
+// Check returns an error when the connector is not running. A connector on standby is
+// healthy, the replica only serves artifacts. ConfigHub is not contacted, so an outage
+// of ConfigHub does not make the replicas unready and stop them from serving artifacts.
+func (s *ConnectorStatus) Check(_ *http.Request) error {
+	if s.state.Load() == connectorStopped {
+		return errors.New("connector is not running")
+	}
+	return nil
+}
+
+// Connected returns an error when the replica is not connected to ConfigHub, because it
+// does not run the connector or the last probe failed. It is not a readiness check, the
+// replica keeps serving artifacts when ConfigHub can't be reached.
+func (s *ConnectorStatus) Connected(_ *http.Request) error {
+	switch s.state.Load() {
+	case connectorStopped:
+		return errors.New("connector is not running")
+	case connectorStandby:
+		return errors.New("connector is on standby, it runs on the leader")
+	}
+	probed, err := s.LastProbe()
+	if !probed {
+		return errors.New("ConfigHub was not probed yet")
+	}
+	return err
+}
+
+// LastProbe returns whether ConfigHub was probed yet, and the error of the last probe.
+func (s *ConnectorStatus) LastProbe() (bool, error) {
+	err := s.lastProbe.Load()
+	if err == nil {
+		return false, nil
+	}
+	return true, *err
+}
+
+// Probe checks whether the ConfigHub API can be reached every interval while the
+// connector is running, until the context is cancelled. The result is cached for
+// LastProbe, recorded as a metric and logged when it changes.
+func (s *ConnectorStatus) Probe(ctx context.Context, interval time.Duration, recorder *metrics.Recorder, log logr.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.state.Load() == connectorRunning {
+			err := s.probe(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			probed, previous := s.LastProbe()
+			s.lastProbe.Store(&err)
+			recorder.RecordConfigHubReachable(err == nil)
+			switch {
+			case err != nil && (!probed || previous == nil):
+				log.Error(err, "ConfigHub can't be reached")
+			case err == nil && probed && previous != nil:
+				log.Info("ConfigHub can be reached again")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ConnectorStatus) probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach ConfigHub: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not reach ConfigHub: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package health
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	livenessEndpoint  = "/healthz"
+	readinessEndpoint = "/readyz"
+
+	// connectivityEndpoint reports whether the external services can be reached.
+	connectivityEndpoint = "/connectivityz"
+)
+
+// Register serves the liveness checks on /healthz and the readiness checks on /readyz.
+// Every check is also served on its own path below the endpoint, like /readyz/storage.
+func Register(mux *http.ServeMux, liveness, readiness map[string]healthz.Checker) {
+	for endpoint, checks := range map[string]map[string]healthz.Checker{
+		livenessEndpoint:  liveness,
+		readinessEndpoint: readiness,
+	} {
+		handler := http.StripPrefix(endpoint, &healthz.Handler{Checks: checks})
+		mux.Handle(endpoint, handler)
+		mux.Handle(endpoint+"/", handler)
+	}
+}
+
+// RegisterConnectivity serves the connectivity checks on /connectivityz. Unlike the
+// readiness checks they are not meant to gate traffic, so the reason of every failing
+// check is reported instead of being withheld.
+func RegisterConnectivity(mux *http.ServeMux, checks map[string]healthz.Checker) {
+	mux.HandleFunc(connectivityEndpoint, func(w http.ResponseWriter, req *http.Request) {
+		status := http.StatusOK
+		lines := []string{}
+		for _, name := range slices.Sorted(maps.Keys(checks)) {
+			err := checks[name](req)
+			if err != nil {
+				status = http.StatusServiceUnavailable
+				lines = append(lines, fmt.Sprintf("[-]%s: %s", name, err))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("[+]%s ok", name))
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	Register(mux, map[string]healthz.Checker{
+		"ping": healthz.Ping,
+	}, map[string]healthz.Checker{
+		"ping": healthz.Ping,
+		"storage": func(_ *http.Request) error {
+			return errors.New("storage is not writable")
+		},
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for path, expected := range map[string]int{
+		"/healthz":                http.StatusOK,
+		"/healthz/ping":           http.StatusOK,
+		"/readyz":                 http.StatusInternalServerError,
+		"/readyz/ping":            http.StatusOK,
+		"/readyz/storage":         http.StatusInternalServerError,
+		"/readyz/unknown":         http.StatusNotFound,
+		"/readyz?exclude=storage": http.StatusOK,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, expected, resp.StatusCode, path)
+	}
+}
+
+func TestRegisterConnectivity(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	RegisterConnectivity(mux, map[string]healthz.Checker{
+		"confighub": func(_ *http.Request) error {
+			return errors.New("could not reach ConfigHub: 503 Service Unavailable")
+		},
+		"kubernetes": healthz.Ping,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/connectivityz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "[-]confighub: could not reach ConfigHub: 503 Service Unavailable\n[+]kubernetes ok\n", string(body))
+}
+
+func TestConnectorStatus(t *testing.T) {
+	t.Parallel()
+
+	status := atomic.Int32{}
+	status.Store(http.StatusOK)
+	probes := make(chan struct{}, 1)
+	confighub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/info", r.URL.Path)
+		w.WriteHeader(int(status.Load()))
+		select {
+		case probes <- struct{}{}:
+		default:
+		}
+	}))
+	defer confighub.Close()
+	connector := NewConnectorStatus(confighub.URL)
+	req := httptest.NewRequest(http.MethodGet, "/readyz/confighub", nil)
+
+	require.EqualError(t, connector.Check(req), "connector is not running")
+	require.EqualError(t, connector.Connected(req), "connector is not running")
+	connector.Standby()
+	require.NoError(t, connector.Check(req))
+	require.EqualError(t, connector.Connected(req), "connector is on standby, it runs on the leader")
+
+	// Only the replica running the connector probes ConfigHub.
+	ctx, cancel := context.WithCancel(t.Context())
+	probing := make(chan struct{})
+	go func() {
+		connector.Probe(ctx, 10*time.Millisecond, nil, logr.Discard())
+		close(probing)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, probes)
+	probed, _ := connector.LastProbe()
+	require.False(t, probed)
+
+	running := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- connector.Run(func() error {
+			close(running)
+			<-stop
+			return nil
+		})
+	}()
+	<-running
+	<-probes
+	require.NoError(t, connector.Check(req))
+	require.Eventually(t, func() bool {
+		probed, err := connector.LastProbe()
+		return probed && err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, connector.Connected(req))
+
+	// A ConfigHub outage is reported, but does not make the replica unready.
+	status.Store(http.StatusServiceUnavailable)
+	require.Eventually(t, func() bool {
+		_, err := connector.LastProbe()
+		return err != nil && err.Error() == "could not reach ConfigHub: 503 Service Unavailable"
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, connector.Check(req))
+	require.EqualError(t, connector.Connected(req), "could not reach ConfigHub: 503 Service Unavailable")
+
+	cancel()
+	<-probing
+	close(stop)
+	require.NoError(t, <-done)
+	require.EqualError(t, connector.Check(req), "connector is not running")
+}
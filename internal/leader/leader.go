@@ -0,0 +1,121 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// ErrLeadershipLost is returned by Run when the replica stopped being the leader before
+// the context was cancelled. The process should exit, as the work it started as the
+// leader may not be stoppable.
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// Options configures the Lease used to elect the leader.
+type Options struct {
+	Name          string
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// DefaultOptions returns the options used by the Kubernetes controllers.
+func DefaultOptions(name, namespace string) Options {
+	return Options{
+		Name:          name,
+		Namespace:     namespace,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// Run blocks until the replica acquires the Lease, and then runs lead with a context
+// which is cancelled when the leadership is lost. The Lease is released when the
+// context is cancelled, so another replica can take over immediately.
+func Run(ctx context.Context, leases coordinationv1client.LeasesGetter, opts Options, log logr.Logger, lead func(ctx context.Context) error) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      opts.Name,
+			Namespace: opts.Namespace,
+		},
+		Client: leases,
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The elector does not wait for the leading callback to return, so the work is
+	// tracked to wait for it, and to not start it after the election stopped.
+	var (
+		mu       sync.Mutex
+		stopped  bool
+		wg       sync.WaitGroup
+		returned bool
+		leadErr  error
+	)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            opts.Name,
+		LeaseDuration:   opts.LeaseDuration,
+		RenewDeadline:   opts.RenewDeadline,
+		RetryPeriod:     opts.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				mu.Lock()
+				if stopped {
+					mu.Unlock()
+					return
+				}
+				wg.Add(1)
+				mu.Unlock()
+				defer wg.Done()
+				log.Info("Acquired leadership", "identity", identity)
+				leadErr = lead(ctx)
+				// The work returned on its own when it did not lose its context.
+				returned = ctx.Err() == nil
+				// Release the Lease when the work returned, so another replica takes over.
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				log.Info("Stopped leading", "identity", identity)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.Info("Waiting for leadership", "leader", current)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create leader elector: %w", err)
+	}
+	elector.Run(electionCtx)
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	wg.Wait()
+	if returned || ctx.Err() != nil {
+		return leadErr
+	}
+	return ErrLeadershipLost
+}
@@ -0,0 +1,65 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testOptions() Options {
+	return Options{
+		Name:          "flux-bridge-leader",
+		Namespace:     "confighub",
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	leases := fake.NewClientset().CoordinationV1()
+
+	// The first replica leads until it is shut down.
+	firstCtx, firstCancel := context.WithCancel(t.Context())
+	firstLeading := make(chan struct{})
+	firstDone := make(chan error)
+	go func() {
+		firstDone <- Run(firstCtx, leases, testOptions(), logr.Discard(), func(ctx context.Context) error {
+			close(firstLeading)
+			<-ctx.Done()
+			return nil
+		})
+	}()
+	<-firstLeading
+
+	// The second replica waits for the Lease to be released.
+	secondLeading := make(chan struct{})
+	secondDone := make(chan error)
+	go func() {
+		secondDone <- Run(t.Context(), leases, testOptions(), logr.Discard(), func(ctx context.Context) error {
+			close(secondLeading)
+			return errors.New("connector run error")
+		})
+	}()
+	select {
+	case <-secondLeading:
+		t.Fatal("second replica leads while the first holds the Lease")
+	case <-time.After(2 * time.Second):
+	}
+
+	firstCancel()
+	require.NoError(t, <-firstDone)
+	select {
+	case <-secondLeading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second replica did not take over the released Lease")
+	}
+	require.EqualError(t, <-secondDone, "connector run error")
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "flux_bridge"
+
+const (
+	// ResultSuccess labels waits which completed.
+	ResultSuccess = "success"
+	// ResultFailure labels waits which failed or timed out.
+	ResultFailure = "failure"
+)
+
+// Recorder records the metrics of the bridge operations. All methods are no-ops on a
+// nil recorder, so metrics are optional for the components using it.
+type Recorder struct {
+	operations         *prometheus.CounterVec
+	operationDuration  *prometheus.HistogramVec
+	waitDuration       *prometheus.HistogramVec
+	artifactSize       prometheus.Histogram
+	garbageCollected   prometheus.Counter
+	driftDetections    *prometheus.CounterVec
+	confighubReachable prometheus.Gauge
+}
+
+// NewRecorder creates the metrics, which have to be registered with Collectors.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Total number of bridge operations by operation and result.",
+		}, []string{"operation", "result"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of bridge operations by operation and result.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"operation", "result"}),
+		waitDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "wait_duration_seconds",
+			Help:      "Time spent waiting for Flux objects to reconcile a revision by kind and result.",
+			Buckets:   []float64{0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"kind", "result"}),
+		artifactSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "artifact_size_bytes",
+			Help:      "Size of the artifacts stored for applied revisions.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+		}),
+		garbageCollected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "artifacts_garbage_collected_total",
+			Help:      "Total number of artifacts removed by garbage collection.",
+		}),
+		driftDetections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "drift_detections_total",
+			Help:      "Total number of refresh operations which detected drift by provider type.",
+		}, []string{"provider"}),
+		confighubReachable: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "confighub_reachable",
+			Help:      "Whether the last probe of the ConfigHub API by the leader succeeded.",
+		}),
+	}
+}
+
+// Collectors returns the collectors of the recorder.
+func (r *Recorder) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.operations,
+		r.operationDuration,
+		r.waitDuration,
+		r.artifactSize,
+		r.garbageCollected,
+		r.driftDetections,
+		r.confighubReachable,
+	}
+}
+
+// RecordOperation records the result and duration of a bridge operation.
+func (r *Recorder) RecordOperation(operation, result string, duration time.Duration) {
+	if r == nil {
+		return
+	}
+	r.operations.WithLabelValues(operation, result).Inc()
+	r.operationDuration.WithLabelValues(operation, result).Observe(duration.Seconds())
+}
+
+// RecordWait records the time spent waiting for a Flux object to reconcile a revision.
+func (r *Recorder) RecordWait(kind string, duration time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	r.waitDuration.WithLabelValues(kind, result).Observe(duration.Seconds())
+}
+
+// RecordArtifactSize records the size of a stored artifact.
+func (r *Recorder) RecordArtifactSize(size int64) {
+	if r == nil {
+		return
+	}
+	r.artifactSize.Observe(float64(size))
+}
+
+// RecordGarbageCollected records the number of artifacts removed by garbage collection.
+func (r *Recorder) RecordGarbageCollected(count int) {
+	if r == nil {
+		return
+	}
+	r.garbageCollected.Add(float64(count))
+}
+
+// RecordDrift records a refresh operation which detected drift.
+func (r *Recorder) RecordDrift(provider string) {
+	if r == nil {
+		return
+	}
+	r.driftDetections.WithLabelValues(provider).Inc()
+}
+
+// RecordConfigHubReachable records the result of a probe of the ConfigHub API.
+func (r *Recorder) RecordConfigHubReachable(reachable bool) {
+	if r == nil {
+		return
+	}
+	value := 0.0
+	if reachable {
+		value = 1
+	}
+	r.confighubReachable.Set(value)
+}
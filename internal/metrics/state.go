@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StateSource reports the state of the bridge which is collected on every scrape.
+type StateSource interface {
+	// StorageSize returns the total size of the files in the artifact storage.
+	StorageSize() (int64, error)
+	// ManagedObjects returns the number of Flux objects managed by the bridge by kind.
+	ManagedObjects() (map[string]int, error)
+}
+
+var (
+	storageSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "storage_size_bytes"),
+		"Total size of the files in the artifact storage.",
+		nil, nil,
+	)
+	managedObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "managed_objects"),
+		"Number of Flux objects managed by the bridge by kind.",
+		[]string{"kind"}, nil,
+	)
+)
+
+type stateCollector struct {
+	source StateSource
+}
+
+// NewStateCollector creates a collector reporting the storage size and the number of
+// managed objects of the source when scraped.
+func NewStateCollector(source StateSource) prometheus.Collector {
+	return &stateCollector{source: source}
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storageSizeDesc
+	ch <- managedObjectsDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	size, err := c.source.StorageSize()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(storageSizeDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(storageSizeDesc, prometheus.GaugeValue, float64(size))
+	}
+	objects, err := c.source.ManagedObjects()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(managedObjectsDesc, err)
+		return
+	}
+	for kind, count := range objects {
+		ch <- prometheus.MustNewConstMetric(managedObjectsDesc, prometheus.GaugeValue, float64(count), kind)
+	}
+}
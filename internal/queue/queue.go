@@ -0,0 +1,248 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// SupersededError is returned by Acquire when a newer revision of the unit was
+// queued before the operation could run.
+type SupersededError struct {
+	Revision int64
+}
+
+func (e *SupersededError) Error() string {
+	return fmt.Sprintf("superseded by revision %d", e.Revision)
+}
+
+// Operation describes an operation queued on a unit.
+type Operation struct {
+	// Revision is the revision of the unit the operation was requested for.
+	Revision int64
+	// Supersedable operations which are still queued are dropped when an operation
+	// for a newer revision of the unit is queued which is also supersedable.
+	Supersedable bool
+}
+
+// ProgressFunc is called with status updates while an operation is queued.
+type ProgressFunc func(msg string)
+
+type waiter struct {
+	op    Operation
+	ready chan struct{}
+	// superseded is set to the newer revision before ready is closed when the
+	// operation was superseded.
+	superseded int64
+}
+
+type unitQueue struct {
+	waiting []*waiter
+}
+
+// Queue serializes the operations on every unit, running them in the order they were
+// queued, and limits the number of operations running at the same time. All methods
+// are no-ops on a nil queue, so queueing is optional for the components using it.
+type Queue struct {
+	mu    sync.Mutex
+	units map[string]*unitQueue
+	slots chan struct{}
+}
+
+// New creates a queue running at most maxConcurrent operations at the same time.
+func New(maxConcurrent int) (*Queue, error) {
+	if maxConcurrent <= 0 {
+		return nil, errors.New("maximum concurrent operations must be greater than zero")
+	}
+	return &Queue{
+		units: map[string]*unitQueue{},
+		slots: make(chan struct{}, maxConcurrent),
+	}, nil
+}
+
+// Lease is held by an operation while it runs on a unit.
+type Lease struct {
+	q    *Queue
+	key  string
+	once sync.Once
+	mu   sync.Mutex
+	// slot is true while the lease holds an operation slot.
+	slot bool
+}
+
+// Acquire waits until the operation can run on the unit with the key, reporting
+// progress while it is queued, and returns a lease which must be released when the
+// operation completed. It waits for the earlier operations on the unit first, and then
+// for a free slot. A SupersededError is returned when the operation was superseded
+// while it was queued.
+func (q *Queue) Acquire(ctx context.Context, key string, op Operation, progress ProgressFunc) (*Lease, error) {
+	if q == nil {
+		return &Lease{}, nil
+	}
+	w, ahead := q.enqueue(key, op)
+	if ahead > 0 {
+		progress(fmt.Sprintf("Queued behind %d operation(s) on the unit", ahead))
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			q.cancel(key, w)
+			return nil, ctx.Err()
+		}
+		if w.superseded > 0 {
+			return nil, &SupersededError{Revision: w.superseded}
+		}
+	}
+
+	err := q.acquireSlot(ctx, progress)
+	if err != nil {
+		q.release(key)
+		return nil, err
+	}
+	return &Lease{q: q, key: key, slot: true}, nil
+}
+
+func (q *Queue) acquireSlot(ctx context.Context, progress ProgressFunc) error {
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	progress(fmt.Sprintf("Waiting for one of %d operation slots to be free", cap(q.slots)))
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release ends the operation, freeing its slot and starting the next operation on the
+// unit. Releasing more than once has no effect.
+func (l *Lease) Release() {
+	if l.q == nil {
+		return
+	}
+	l.once.Do(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.slot {
+			<-l.q.slots
+			l.slot = false
+		}
+		l.q.release(l.key)
+	})
+}
+
+// Yield frees the slot of the operation while running the wait function, so other
+// operations can run while it waits on something which does not need a slot, like
+// another unit. The unit stays locked, and the slot is taken again before Yield
+// returns, reporting progress while waiting for it.
+func (l *Lease) Yield(ctx context.Context, progress ProgressFunc, wait func() error) error {
+	if l.q == nil {
+		return wait()
+	}
+	l.mu.Lock()
+	if l.slot {
+		<-l.q.slots
+		l.slot = false
+	}
+	l.mu.Unlock()
+	waitErr := wait()
+	err := l.q.acquireSlot(ctx, progress)
+	if err != nil {
+		return errors.Join(waitErr, err)
+	}
+	l.mu.Lock()
+	l.slot = true
+	l.mu.Unlock()
+	return waitErr
+}
+
+// TryLock locks the unit with the key if no operation is queued or running on it,
+// without taking a slot, and returns a function unlocking it.
+func (q *Queue) TryLock(key string) (func(), bool) {
+	if q == nil {
+		return func() {}, true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.units[key]; ok {
+		return nil, false
+	}
+	q.units[key] = &unitQueue{waiting: []*waiter{{}}}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.release(key)
+		})
+	}, true
+}
+
+// enqueue adds the operation to the queue of the unit, superseding the queued
+// operations it replaces, and returns how many operations are ahead of it.
+func (q *Queue) enqueue(key string, op Operation) (*waiter, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := &waiter{op: op, ready: make(chan struct{})}
+	uq, ok := q.units[key]
+	if !ok {
+		close(w.ready)
+		q.units[key] = &unitQueue{waiting: []*waiter{w}}
+		return w, 0
+	}
+	if op.Supersedable {
+		// The first waiter is running and can't be superseded.
+		kept := uq.waiting[:1]
+		for _, other := range uq.waiting[1:] {
+			if other.op.Supersedable && other.op.Revision < op.Revision {
+				other.superseded = op.Revision
+				close(other.ready)
+				continue
+			}
+			kept = append(kept, other)
+		}
+		uq.waiting = kept
+	}
+	uq.waiting = append(uq.waiting, w)
+	return w, len(uq.waiting) - 1
+}
+
+// cancel removes a waiter which gave up before it could run.
+func (q *Queue) cancel(key string, w *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	uq, ok := q.units[key]
+	if !ok || w.superseded > 0 {
+		return
+	}
+	i := slices.Index(uq.waiting, w)
+	switch {
+	case i == 0:
+		// The waiter became ready while it gave up, so it hands over to the next.
+		q.releaseLocked(key)
+	case i > 0:
+		uq.waiting = slices.Delete(uq.waiting, i, i+1)
+	}
+}
+
+// release removes the running operation of the unit and starts the next one.
+func (q *Queue) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(key)
+}
+
+func (q *Queue) releaseLocked(key string) {
+	uq, ok := q.units[key]
+	if !ok {
+		return
+	}
+	uq.waiting = uq.waiting[1:]
+	if len(uq.waiting) == 0 {
+		delete(q.units, key)
+		return
+	}
+	close(uq.waiting[0].ready)
+}
@@ -0,0 +1,212 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	t.Parallel()
+
+	q, err := New(2)
+	require.NoError(t, err)
+	lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 1}, nil)
+	require.NoError(t, err)
+
+	// Operations on the same unit wait for the running one.
+	msgs := make(chan string, 10)
+	progress := func(msg string) {
+		msgs <- msg
+	}
+	acquired := make(chan *Lease)
+	go func() {
+		lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 2}, progress)
+		if err == nil {
+			acquired <- lease
+		}
+	}()
+	require.Equal(t, "Queued behind 1 operation(s) on the unit", <-msgs)
+	select {
+	case <-acquired:
+		t.Fatal("operation acquired the unit while another operation was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Operations on other units run concurrently.
+	leaseB, err := q.Acquire(t.Context(), "b", Operation{Revision: 1}, nil)
+	require.NoError(t, err)
+
+	lease.Release()
+	next := <-acquired
+
+	// The slots are taken by the units a and b.
+	go func() {
+		lease, err := q.Acquire(t.Context(), "c", Operation{Revision: 1}, progress)
+		if err == nil {
+			acquired <- lease
+		}
+	}()
+	require.Equal(t, "Waiting for one of 2 operation slots to be free", <-msgs)
+	leaseB.Release()
+	leaseC := <-acquired
+	leaseC.Release()
+	next.Release()
+	// Releasing twice has no effect.
+	next.Release()
+	require.Empty(t, q.units)
+	require.Empty(t, q.slots)
+}
+
+func TestAcquireSuperseded(t *testing.T) {
+	t.Parallel()
+
+	q, err := New(1)
+	require.NoError(t, err)
+	lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 1, Supersedable: true}, nil)
+	require.NoError(t, err)
+
+	type result struct {
+		revision int64
+		err      error
+	}
+	results := make(chan result, 3)
+	var wg sync.WaitGroup
+	acquire := func(op Operation) {
+		queued := make(chan struct{})
+		closeQueued := sync.OnceFunc(func() {
+			close(queued)
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := q.Acquire(t.Context(), "a", op, func(string) {
+				closeQueued()
+			})
+			if err == nil {
+				lease.Release()
+			}
+			results <- result{revision: op.Revision, err: err}
+		}()
+		<-queued
+	}
+	acquire(Operation{Revision: 2, Supersedable: true})
+	// A refresh is never superseded.
+	acquire(Operation{Revision: 2})
+	acquire(Operation{Revision: 3, Supersedable: true})
+
+	// The queued apply of revision 2 is dropped for revision 3.
+	r := <-results
+	require.Equal(t, int64(2), r.revision)
+	superseded := &SupersededError{}
+	require.ErrorAs(t, r.err, &superseded)
+	require.EqualError(t, r.err, "superseded by revision 3")
+
+	lease.Release()
+	wg.Wait()
+	close(results)
+	for r := range results {
+		require.NoError(t, r.err)
+	}
+	require.Empty(t, q.units)
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	t.Parallel()
+
+	q, err := New(1)
+	require.NoError(t, err)
+	lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 1}, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx, "a", Operation{Revision: 2}, func(string) {
+			cancel()
+		})
+		done <- err
+	}()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.Len(t, q.units["a"].waiting, 1)
+
+	lease.Release()
+	require.Empty(t, q.units)
+}
+
+func TestTryLock(t *testing.T) {
+	t.Parallel()
+
+	q, err := New(1)
+	require.NoError(t, err)
+	unlock, ok := q.TryLock("a")
+	require.True(t, ok)
+	_, ok = q.TryLock("a")
+	require.False(t, ok)
+
+	// An operation waits for the lock, but does not wait for a slot.
+	msgs := make(chan string, 1)
+	acquired := make(chan *Lease)
+	go func() {
+		lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 1}, func(msg string) {
+			msgs <- msg
+		})
+		if err == nil {
+			acquired <- lease
+		}
+	}()
+	require.Equal(t, "Queued behind 1 operation(s) on the unit", <-msgs)
+	unlock()
+	lease := <-acquired
+	_, ok = q.TryLock("a")
+	require.False(t, ok)
+	lease.Release()
+
+	_, ok = q.TryLock("a")
+	require.True(t, ok)
+
+	var nilQueue *Queue
+	unlock, ok = nilQueue.TryLock("a")
+	require.True(t, ok)
+	unlock()
+	lease, err = nilQueue.Acquire(t.Context(), "a", Operation{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, lease.Yield(t.Context(), nil, func() error { return nil }))
+	lease.Release()
+}
+
+func TestYield(t *testing.T) {
+	t.Parallel()
+
+	q, err := New(1)
+	require.NoError(t, err)
+	lease, err := q.Acquire(t.Context(), "a", Operation{Revision: 1}, nil)
+	require.NoError(t, err)
+
+	// The slot is free while the operation waits, but its unit stays locked.
+	msgs := make(chan string, 1)
+	err = lease.Yield(t.Context(), func(msg string) {
+		msgs <- msg
+	}, func() error {
+		_, ok := q.TryLock("a")
+		require.False(t, ok)
+		leaseB, err := q.Acquire(t.Context(), "b", Operation{Revision: 1}, nil)
+		require.NoError(t, err)
+		go func() {
+			<-msgs
+			leaseB.Release()
+		}()
+		return errors.New("dependency not ready")
+	})
+	// The slot is taken again once the other operation released it.
+	require.EqualError(t, err, "dependency not ready")
+	require.Len(t, q.slots, 1)
+
+	lease.Release()
+	require.Empty(t, q.units)
+	require.Empty(t, q.slots)
+}
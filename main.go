@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alexflint/go-arg"
 
@@ -14,39 +17,99 @@ import (
 	"github.com/fluxcd/pkg/artifact/server"
 	gotkstorage "github.com/fluxcd/pkg/artifact/storage"
 	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	kconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	klog "sigs.k8s.io/controller-runtime/pkg/log"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/confighubai/flux-bridge/internal/bridge"
 	"github.com/confighubai/flux-bridge/internal/controller"
+	"github.com/confighubai/flux-bridge/internal/health"
+	"github.com/confighubai/flux-bridge/internal/leader"
+	"github.com/confighubai/flux-bridge/internal/metrics"
+	"github.com/confighubai/flux-bridge/internal/queue"
 )
 
 type Arguments struct {
 	Addr         string `arg:"--addr,env:ADDR" default:":8080"`
+	MetricsAddr  string `arg:"--metrics-addr,env:METRICS_ADDR" default:":8081"`
+	HealthAddr   string `arg:"--health-addr,env:HEALTH_ADDR" default:":9440"`
+	LeaderElect  bool   `arg:"--leader-elect,env:LEADER_ELECT"`
 	DataDir      string `arg:"--data-dir,env:DATA_DIR"`
 	Namespace    string `arg:"--namespace,env:NAMESPACE,required"`
 	WorkerName   string `arg:"--name,env:CONFIGHUB_WORKER_NAME" default:"flux-bridge-poc"`
 	WorkerID     string `arg:"--worker-id,env:CONFIGHUB_WORKER_ID,required"`
 	WorkerSecret string `arg:"--worker-secret,env:CONFIGHUB_WORKER_SECRET,required"`
 	ConfigHubURL string `arg:"--confighub-url,env:CONFIGHUB_URL" default:"https://hub.confighub.com"`
+
+	MaxConcurrentOperations int `arg:"--max-concurrent-operations,env:MAX_CONCURRENT_OPERATIONS" default:"4"`
+
+	ArtifactRetentionRecords int           `arg:"--artifact-retention-records,env:ARTIFACT_RETENTION_RECORDS" default:"10"`
+	ArtifactRetentionTTL     time.Duration `arg:"--artifact-retention-ttl,env:ARTIFACT_RETENTION_TTL" default:"168h"`
+	ArtifactMaxSize          int64         `arg:"--artifact-max-size,env:ARTIFACT_MAX_SIZE" default:"0"`
+	ArtifactGCInterval       time.Duration `arg:"--artifact-gc-interval,env:ARTIFACT_GC_INTERVAL" default:"1m"`
+
+	SchemaDir  string `arg:"--schema-dir,env:SCHEMA_DIR"`
+	PolicyFile string `arg:"--policy-file,env:POLICY_FILE"`
+
+	TenantNamespace      string `arg:"--tenant-namespace,env:TENANT_NAMESPACE"`
+	TenantServiceAccount string `arg:"--tenant-service-account,env:TENANT_SERVICE_ACCOUNT" default:"tenant-{{ .Space }}"`
+	TenantClusterRole    string `arg:"--tenant-cluster-role,env:TENANT_CLUSTER_ROLE" default:"admin"`
+
+	LogLevel    string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	LogEncoding string `arg:"--log-encoding,env:LOG_ENCODING" default:"json"`
 }
 
 func main() {
 	args := &Arguments{}
 	arg.MustParse(args)
 
-	err := run(*args)
+	log, err := newLogger(args.LogLevel, args.LogEncoding)
+	if err != nil {
+		fmt.Println("invalid log options", err)
+		os.Exit(1)
+	}
+	err = run(*args, log)
 	if err != nil {
-		fmt.Println("flux-bridge shutdown with error", err)
+		log.Error(err, "flux-bridge shutdown with error")
 		os.Exit(1)
 	}
-	fmt.Println("flux-bridge shutdown, gracefully")
+	log.Info("flux-bridge shutdown, gracefully")
 }
 
-func run(args Arguments) error {
-	klog.SetLogger(logr.Discard())
+// newLogger creates a structured logger writing to stderr. Debug logs are written at
+// verbosity level 1.
+func newLogger(level, encoding string) (logr.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return logr.Logger{}, err
+	}
+	opts := []crzap.Opts{
+		crzap.Level(lvl),
+		func(o *crzap.Options) {
+			o.TimeEncoder = zapcore.ISO8601TimeEncoder
+		},
+	}
+	switch encoding {
+	case "json":
+		opts = append(opts, crzap.JSONEncoder())
+	case "console":
+		opts = append(opts, crzap.ConsoleEncoder())
+	default:
+		return logr.Logger{}, fmt.Errorf("unknown log encoding %q, expected json or console", encoding)
+	}
+	return crzap.New(opts...), nil
+}
+
+func run(args Arguments, log logr.Logger) error {
+	klog.SetLogger(log)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	defer cancel()
@@ -57,7 +120,7 @@ func run(args Arguments) error {
 	if err != nil {
 		return err
 	}
-	kubeClient, err := client.New(kubeCfg, client.Options{})
+	kubeClient, err := client.NewWithWatch(kubeCfg, client.Options{})
 	if err != nil {
 		return err
 	}
@@ -72,9 +135,11 @@ func run(args Arguments) error {
 		dataDir = tmpDir
 	}
 	artifactCfg := &config.Options{
-		StoragePath:       dataDir,
-		StorageAddress:    args.Addr,
-		StorageAdvAddress: fmt.Sprintf("flux-bridge.%s.svc.cluster.local.", args.Namespace),
+		StoragePath:              dataDir,
+		StorageAddress:           args.Addr,
+		StorageAdvAddress:        fmt.Sprintf("flux-bridge.%s.svc.cluster.local.", args.Namespace),
+		ArtifactRetentionTTL:     args.ArtifactRetentionTTL,
+		ArtifactRetentionRecords: args.ArtifactRetentionRecords,
 	}
 	storage, err := gotkstorage.New(artifactCfg)
 	if err != nil {
@@ -87,12 +152,67 @@ func run(args Arguments) error {
 	if err != nil {
 		return err
 	}
-
+	recorder := metrics.NewRecorder()
+	fluxCtrl = fluxCtrl.WithMetrics(recorder).WithLogger(log.WithName("controller"))
+	// Events are recorded on the External Artifacts and Flux objects.
+	coreClient, err := corev1client.NewForConfig(kubeCfg)
+	if err != nil {
+		return err
+	}
+	broadcaster := record.NewBroadcaster()
+	defer broadcaster.Shutdown()
+	broadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: coreClient.Events("")})
+	fluxCtrl = fluxCtrl.WithEventRecorder(broadcaster.NewRecorder(kubeClient.Scheme(), corev1.EventSource{Component: controller.ControllerName}))
+	if args.SchemaDir != "" {
+		schemas, err := controller.NewSchemaValidator(args.SchemaDir)
+		if err != nil {
+			return fmt.Errorf("could not load schemas: %w", err)
+		}
+		fluxCtrl = fluxCtrl.WithSchemaValidator(schemas)
+	}
+	if args.PolicyFile != "" {
+		policy, err := controller.LoadPolicy(args.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("could not load policy: %w", err)
+		}
+		fluxCtrl = fluxCtrl.WithPolicy(policy)
+	}
+	if args.TenantNamespace != "" {
+		tenancy, err := controller.NewTenancy(args.TenantNamespace, args.TenantServiceAccount, args.TenantClusterRole)
+		if err != nil {
+			return err
+		}
+		fluxCtrl = fluxCtrl.WithTenancy(tenancy)
+		err = fluxCtrl.CheckTenancy(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	// Operations on a unit run one at a time, and garbage collection skips units with a
+	// running operation.
+	operations, err := queue.New(args.MaxConcurrentOperations)
+	if err != nil {
+		return err
+	}
+	fluxCtrl = fluxCtrl.WithLocker(bridge.NewUnitLocker(operations))
 	// ConfigHub fluxBridge and worker.
 	fluxBridge, err := bridge.NewFluxBridge(fluxCtrl, args.WorkerName)
 	if err != nil {
 		return fmt.Errorf("could not create Flux bridge: %w", err)
 	}
+	fluxBridge = fluxBridge.WithContext(gCtx).WithConfigHubURL(args.ConfigHubURL).WithQueue(operations).WithMetrics(recorder).WithLogger(log.WithName("bridge"))
+
+	// Metrics server.
+	registry, err := metrics.NewRegistry(append(recorder.Collectors(), metrics.NewStateCollector(fluxCtrl))...)
+	if err != nil {
+		return fmt.Errorf("could not register metrics: %w", err)
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler(registry))
+	g.Go(func() error {
+		return serveHTTP(gCtx, args.MetricsAddr, metricsMux)
+	})
+
 	bridgeDispatcher := worker.NewBridgeDispatcher()
 	bridgeDispatcher.RegisterBridge(fluxBridge)
 	connector, err := worker.NewConnector(worker.ConnectorOptions{
@@ -104,13 +224,80 @@ func run(args Arguments) error {
 	if err != nil {
 		return fmt.Errorf("could not create connector: %w", err)
 	}
+	connectorStatus := health.NewConnectorStatus(args.ConfigHubURL)
+	// The replica serves artifacts while it waits to become the leader or restores the
+	// artifact storage, so it is ready before the connector runs.
+	connectorStatus.Standby()
+
+	// Health probes.
+	healthMux := http.NewServeMux()
+	health.Register(healthMux, map[string]healthz.Checker{
+		"ping": healthz.Ping,
+	}, map[string]healthz.Checker{
+		"confighub": connectorStatus.Check,
+		"kubernetes": func(req *http.Request) error {
+			return fluxCtrl.CheckAPI(req.Context())
+		},
+		"storage": func(_ *http.Request) error {
+			return fluxCtrl.CheckStorage()
+		},
+	})
+	health.RegisterConnectivity(healthMux, map[string]healthz.Checker{
+		"confighub": connectorStatus.Connected,
+	})
 	g.Go(func() error {
-		err := connector.Start()
+		return serveHTTP(gCtx, args.HealthAddr, healthMux)
+	})
+
+	// Only the leader accepts ConfigHub work and manages the artifact storage, while
+	// all replicas serve artifacts.
+	lead := func(ctx context.Context) error {
+		// Restore the artifacts lost when the storage was not persisted across restarts.
+		err := fluxCtrl.Rehydrate(ctx)
 		if err != nil {
-			return fmt.Errorf("connector run error: %w", err)
+			return fmt.Errorf("could not rehydrate artifact storage: %w", err)
 		}
-		return nil
-	})
+		lg, lgCtx := errgroup.WithContext(ctx)
+		lg.Go(func() error {
+			return fluxCtrl.RunGarbageCollection(lgCtx, args.ArtifactGCInterval, args.ArtifactMaxSize)
+		})
+		lg.Go(func() error {
+			// ConfigHub reachability is only reported, an outage does not affect readiness.
+			connectorStatus.Probe(lgCtx, health.DefaultProbeInterval, recorder, log.WithName("confighub"))
+			return nil
+		})
+		lg.Go(func() error {
+			// The connector can't be stopped, it is left to the process exit.
+			connectorErr := make(chan error, 1)
+			go func() {
+				connectorErr <- connectorStatus.Run(connector.Start)
+			}()
+			select {
+			case err := <-connectorErr:
+				if err != nil {
+					return fmt.Errorf("connector run error: %w", err)
+				}
+				return nil
+			case <-lgCtx.Done():
+				return nil
+			}
+		})
+		return lg.Wait()
+	}
+	if args.LeaderElect {
+		coordinationClient, err := coordinationv1client.NewForConfig(kubeCfg)
+		if err != nil {
+			return err
+		}
+		g.Go(func() error {
+			opts := leader.DefaultOptions(args.WorkerName+"-leader", args.Namespace)
+			return leader.Run(gCtx, coordinationClient, opts, log.WithName("leader"), lead)
+		})
+	} else {
+		g.Go(func() error {
+			return lead(gCtx)
+		})
+	}
 
 	// Artifact file server.
 	err = g.Wait()
@@ -119,3 +306,23 @@ func run(args Arguments) error {
 	}
 	return nil
 }
+
+// serveHTTP serves the handler on the address until the context is cancelled.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}